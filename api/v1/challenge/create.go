@@ -14,8 +14,10 @@ import (
 	"github.com/ctfer-io/chall-manager/api/v1/instance"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
+	"github.com/ctfer-io/chall-manager/pkg/pool"
 )
 
 func (store *Store) CreateChallenge(ctx context.Context, req *CreateChallengeRequest) (*Challenge, error) {
@@ -27,6 +29,29 @@ func (store *Store) CreateChallenge(ctx context.Context, req *CreateChallengeReq
 	if err := common.CheckPooler([]string{"min", "max"}, req.GetMin(), req.GetMax()); err != nil {
 		return nil, err
 	}
+	schedule := toSchedule(req.GetSchedule())
+	if err := common.CheckSchedule([]string{"schedule"}, schedule); err != nil {
+		return nil, err
+	}
+	if err := common.CheckWeight([]string{"weight"}, req.GetWeight()); err != nil {
+		return nil, err
+	}
+	if err := common.CheckPoolMaxAge([]string{"pool_max_age"}, req.GetPoolMaxAge()); err != nil {
+		return nil, err
+	}
+	if err := common.CheckSourceQuota([]string{"source_quota"}, req.GetSourceQuota()); err != nil {
+		return nil, err
+	}
+	if err := common.CheckSharing([]string{"sharing", "group_size"}, req.GetSharing().String(), req.GetGroupSize()); err != nil {
+		return nil, err
+	}
+	if err := common.CheckRenewal([]string{"max_renewals", "max_lifetime", "renew_window"}, req.GetMaxRenewals(), req.GetMaxLifetime(), req.GetRenewWindow()); err != nil {
+		return nil, err
+	}
+	variants := toVariants(req.GetVariants())
+	if err := common.CheckVariants([]string{"variants"}, variants); err != nil {
+		return nil, err
+	}
 
 	// 1. Lock R TOTW
 	span.AddEvent("lock TOTW")
@@ -114,22 +139,28 @@ func (store *Store) CreateChallenge(ctx context.Context, req *CreateChallengeReq
 	// 6. Prepare challenge
 	logger.Info(ctx, "creating challenge")
 	fschall := &fs.Challenge{
-		ID:         req.GetId(),
-		Scenario:   req.GetScenario(),
-		Timeout:    toDuration(req.GetTimeout()),
-		Until:      toTime(req.GetUntil()),
-		Additional: req.GetAdditional(),
-		Min:        req.GetMin(),
-		Max:        req.GetMax(),
+		ID:                req.GetId(),
+		Scenario:          req.GetScenario(),
+		Timeout:           toDuration(req.GetTimeout()),
+		Until:             toTime(req.GetUntil()),
+		Additional:        req.GetAdditional(),
+		Min:               req.GetMin(),
+		Max:               req.GetMax(),
+		Schedule:          schedule,
+		Autoscale:         req.GetAutoscale(),
+		Weight:            req.GetWeight(),
+		PoolMaxAge:        toDuration(req.GetPoolMaxAge()),
+		Variants:          variants,
+		ExcludePausedTime: req.GetExcludePausedTime(),
+		SourceQuota:       req.GetSourceQuota(),
+		Sharing:           req.GetSharing().String(),
+		GroupSize:         req.GetGroupSize(),
+		MaxRenewals:       req.GetMaxRenewals(),
+		MaxLifetime:       toDuration(req.GetMaxLifetime()),
+		RenewWindow:       toDuration(req.GetRenewWindow()),
 	}
 
-	// 7. Spin up instances if pool is configured. Lock is acquired at challenge level
-	//    hence don't need to be held too.
-	for range req.GetMin() {
-		go instance.SpinUp(ctx, req.GetId())
-	}
-
-	// 8. Save challenge on filesystem, and respond to API call
+	// 7. Save challenge on filesystem
 	if err := fschall.Save(); err != nil {
 		logger.Error(ctx, "saving challenge",
 			zap.Error(err),
@@ -137,18 +168,39 @@ func (store *Store) CreateChallenge(ctx context.Context, req *CreateChallengeReq
 		return nil, errs.ErrInternalNoSub
 	}
 
+	// 8. Fill the pools if configured. The leader does it once the challenge
+	//    lock is released, and respond to API call.
+	minVal, _ := instance.PoolBounds(ctx, fschall, time.Now())
+	if minVal != 0 || len(fschall.Variants) != 0 {
+		instance.RequestReconcile(ctx, req.GetId())
+	}
+
 	logger.Info(ctx, "challenge created successfully")
 	common.ChallengesUDCounter().Add(ctx, 1)
+	common.EmitChallenge(ctx, events.TypeChallengeCreated, req.GetId())
 
 	chall := &Challenge{
-		Id:         req.GetId(),
-		Scenario:   req.GetScenario(),
-		Timeout:    req.GetTimeout(),
-		Until:      req.GetUntil(),
-		Instances:  []*instance.Instance{},
-		Additional: req.GetAdditional(),
-		Min:        req.GetMin(),
-		Max:        req.GetMax(),
+		Id:                req.GetId(),
+		Scenario:          req.GetScenario(),
+		Timeout:           req.GetTimeout(),
+		Until:             req.GetUntil(),
+		Instances:         []*instance.Instance{},
+		Additional:        req.GetAdditional(),
+		Min:               req.GetMin(),
+		Max:               req.GetMax(),
+		Schedule:          req.GetSchedule(),
+		Autoscale:         req.GetAutoscale(),
+		Weight:            req.GetWeight(),
+		Breaker:           toPBBreaker(ctx, req.GetId()),
+		PoolMaxAge:        req.GetPoolMaxAge(),
+		Variants:          toPBVariants(variants),
+		ExcludePausedTime: req.GetExcludePausedTime(),
+		SourceQuota:       req.GetSourceQuota(),
+		Sharing:           req.GetSharing(),
+		GroupSize:         req.GetGroupSize(),
+		MaxRenewals:       req.GetMaxRenewals(),
+		MaxLifetime:       req.GetMaxLifetime(),
+		RenewWindow:       req.GetRenewWindow(),
 	}
 
 	// 9. Unlock RW challenge
@@ -172,3 +224,35 @@ func toTime(d *timestamppb.Timestamp) *time.Time {
 	td := d.AsTime()
 	return &td
 }
+
+func toSchedule(pbs []*PoolWindow) []pool.Window {
+	if len(pbs) == 0 {
+		return nil
+	}
+	schedule := make([]pool.Window, 0, len(pbs))
+	for _, pbw := range pbs {
+		schedule = append(schedule, pool.Window{
+			From: pbw.GetFrom().AsTime(),
+			To:   pbw.GetTo().AsTime(),
+			Min:  pbw.GetMin(),
+			Max:  pbw.GetMax(),
+		})
+	}
+	return schedule
+}
+
+func toVariants(pbs []*PoolVariant) []pool.Variant {
+	if len(pbs) == 0 {
+		return nil
+	}
+	variants := make([]pool.Variant, 0, len(pbs))
+	for _, pbv := range pbs {
+		variants = append(variants, pool.Variant{
+			Name:       pbv.GetName(),
+			Additional: pbv.GetAdditional(),
+			Min:        pbv.GetMin(),
+			Max:        pbv.GetMax(),
+		})
+	}
+	return variants
+}
@@ -11,8 +11,10 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/api/v1/instance"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
@@ -144,6 +146,15 @@ func (store *Store) DeleteChallenge(ctx context.Context, req *DeleteChallengeReq
 				}
 			}
 
+			if err == nil && sourceID != "" {
+				sourceIDs, err := fs.LookupClaims(fsist.ChallengeID, fsist.Identity)
+				if err == nil {
+					for _, sourceID := range sourceIDs {
+						common.EmitInstance(ctx, events.TypeInstanceDeleted, fsist, sourceID)
+					}
+				}
+			}
+
 			cerr <- err
 
 			common.InstancesUDCounter().Add(ctx, -1,
@@ -168,7 +179,13 @@ func (store *Store) DeleteChallenge(ctx context.Context, req *DeleteChallengeReq
 	}
 
 	logger.Info(ctx, "challenge deleted successfully")
+	if err := instance.ForgetPool(ctx, req.GetId()); err != nil {
+		logger.Error(ctx, "forgetting challenge pool",
+			zap.Error(err),
+		)
+	}
 	common.ChallengesUDCounter().Add(ctx, -1)
+	common.EmitChallenge(ctx, events.TypeChallengeDeleted, req.GetId())
 
 	return nil, nil
 }
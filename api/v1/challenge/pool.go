@@ -0,0 +1,211 @@
+package challenge
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/api/v1/instance"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (store *Store) GetPoolStatus(ctx context.Context, req *GetPoolStatusRequest) (*PoolStatus, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.GetId())
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.GetId())
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+		}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+		}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. Fetch challenge info
+	fschall, err := fs.LoadChallenge(req.GetId())
+	if err != nil {
+		// If challenge not found
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return nil, err
+		}
+		// Else deal with it as an internal server error
+		logger.Error(ctx, "loading challenge",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	// 5. Classify instances between claimed and pooled
+	ists, err := fs.ListInstances(req.GetId())
+	if err != nil {
+		logger.Error(ctx, "listing instances",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	now := time.Now()
+	var claimed int64
+	pooled := []*PooledInstance{}
+	for _, ist := range ists {
+		_, err := fs.LookupClaim(req.GetId(), ist)
+		if err == nil {
+			claimed++
+			continue
+		}
+		if err, ok := err.(*errs.InstanceExist); !ok || err.Exist {
+			logger.Error(ctx, "looking up for claim",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+
+		fsist, err := fs.LoadInstance(req.GetId(), ist)
+		if err != nil {
+			logger.Error(global.WithIdentity(ctx, ist), "loading instance",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		pooled = append(pooled, &PooledInstance{
+			Identity: ist,
+			Since:    timestamppb.New(fsist.Since),
+			Age:      durationpb.New(now.Sub(fsist.Since)),
+			Variant:  fsist.Variant,
+		})
+	}
+
+	// 6. Complete with spin-ups statistics
+	failures, avg, err := instance.PoolStats(ctx, req.GetId())
+	if err != nil {
+		logger.Error(ctx, "reading pool statistics",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	inflight, err := instance.Inflight(ctx, req.GetId())
+	if err != nil {
+		logger.Error(ctx, "counting in-flight spin-ups",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	pbfailures := make([]*SpinUpFailure, 0, len(failures))
+	for _, f := range failures {
+		pbfailures = append(pbfailures, &SpinUpFailure{
+			At:    timestamppb.New(f.At),
+			Error: f.Error,
+		})
+	}
+	var pbavg *durationpb.Duration
+	if avg != 0 {
+		pbavg = durationpb.New(avg)
+	}
+
+	return &PoolStatus{
+		Id:            req.GetId(),
+		Min:           fschall.Min,
+		Max:           fschall.Max,
+		Pooled:        pooled,
+		Claimed:       claimed,
+		Inflight:      inflight,
+		Failures:      pbfailures,
+		AverageSpinUp: pbavg,
+		Breaker:       toPBBreaker(ctx, req.GetId()),
+	}, nil
+}
+
+func (store *Store) ResetPoolBreaker(ctx context.Context, req *ResetPoolBreakerRequest) (*emptypb.Empty, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, req.GetId())
+
+	if err := fs.CheckChallenge(req.GetId()); err != nil {
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return nil, err
+		}
+		logger.Error(ctx, "checking challenge",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+
+	if err := instance.ResetBreaker(ctx, req.GetId()); err != nil {
+		logger.Error(ctx, "resetting pool circuit breaker",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	logger.Info(ctx, "pool circuit breaker reset")
+
+	// Resume spin-ups right away rather than waiting for the next claim or
+	// scheduler run.
+	instance.RequestReconcile(ctx, req.GetId())
+
+	return nil, nil
+}
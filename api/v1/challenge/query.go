@@ -122,7 +122,8 @@ func (store *Store) QueryChallenge(_ *emptypb.Empty, server ChallengeStore_Query
 				return
 			}
 			for _, ist := range ists {
-				sourceID, err := fs.LookupClaim(id, ist)
+				// A shared instance is listed for each of its sources
+				sourceIDs, err := fs.LookupClaims(id, ist)
 				if err, ok := err.(*errs.InstanceExist); ok && !err.Exist {
 					// no claim file => in pool
 					continue
@@ -131,7 +132,9 @@ func (store *Store) QueryChallenge(_ *emptypb.Empty, server ChallengeStore_Query
 					cerr <- err
 					return
 				}
-				clmIsts[sourceID] = ist
+				for _, sourceID := range sourceIDs {
+					clmIsts[sourceID] = ist
+				}
 			}
 			oists := make([]*instance.Instance, 0, len(clmIsts))
 			for sourceID, identity := range clmIsts {
@@ -158,20 +161,36 @@ func (store *Store) QueryChallenge(_ *emptypb.Empty, server ChallengeStore_Query
 						}
 						return nil
 					}(),
-					Flags:      fsist.Flags,
-					Additional: fsist.Additional,
+					Flags:         fsist.Flags,
+					Additional:    fsist.Additional,
+					Status:        instance.Status(fsist),
+					StatusMessage: fsist.StatusMessage,
+					Renewals:      fsist.Renewals,
 				})
 			}
 
 			if err := qs.SendMsg(&Challenge{
-				Id:         id,
-				Scenario:   fschall.Scenario,
-				Timeout:    toPBDuration(fschall.Timeout),
-				Until:      toPBTimestamp(fschall.Until),
-				Instances:  oists,
-				Additional: fschall.Additional,
-				Min:        fschall.Min,
-				Max:        fschall.Max,
+				Id:                id,
+				Scenario:          fschall.Scenario,
+				Timeout:           toPBDuration(fschall.Timeout),
+				Until:             toPBTimestamp(fschall.Until),
+				Instances:         oists,
+				Additional:        fschall.Additional,
+				Min:               fschall.Min,
+				Max:               fschall.Max,
+				Schedule:          toPBSchedule(fschall.Schedule),
+				Autoscale:         fschall.Autoscale,
+				Weight:            fschall.Weight,
+				Breaker:           toPBBreaker(ctx, fschall.ID),
+				PoolMaxAge:        toPBDuration(fschall.PoolMaxAge),
+				Variants:          toPBVariants(fschall.Variants),
+				ExcludePausedTime: fschall.ExcludePausedTime,
+				SourceQuota:       fschall.SourceQuota,
+				Sharing:           SharingMode(SharingMode_value[fschall.Sharing]),
+				GroupSize:         fschall.GroupSize,
+				MaxRenewals:       fschall.MaxRenewals,
+				MaxLifetime:       toPBDuration(fschall.MaxLifetime),
+				RenewWindow:       toPBDuration(fschall.RenewWindow),
 			}); err != nil {
 				cerr <- err
 				return
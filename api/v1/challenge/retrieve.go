@@ -16,6 +16,7 @@ import (
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
+	"github.com/ctfer-io/chall-manager/pkg/pool"
 )
 
 func (store *Store) RetrieveChallenge(ctx context.Context, req *RetrieveChallengeRequest) (*Challenge, error) {
@@ -111,7 +112,8 @@ func (store *Store) RetrieveChallenge(ctx context.Context, req *RetrieveChalleng
 		return nil, errs.ErrInternalNoSub
 	}
 	for _, ist := range ists {
-		sourceID, err := fs.LookupClaim(req.GetId(), ist)
+		// A shared instance is listed for each of its sources
+		sourceIDs, err := fs.LookupClaims(req.GetId(), ist)
 		if err, ok := err.(*errs.InstanceExist); ok && !err.Exist {
 			// no claim file => in pool
 			continue
@@ -122,7 +124,9 @@ func (store *Store) RetrieveChallenge(ctx context.Context, req *RetrieveChalleng
 			)
 			return nil, errs.ErrInternalNoSub
 		}
-		clmIsts[sourceID] = ist
+		for _, sourceID := range sourceIDs {
+			clmIsts[sourceID] = ist
+		}
 	}
 	oists := make([]*instance.Instance, 0, len(clmIsts))
 	for sourceID, identity := range clmIsts {
@@ -152,20 +156,36 @@ func (store *Store) RetrieveChallenge(ctx context.Context, req *RetrieveChalleng
 				}
 				return nil
 			}(),
-			Flags:      fsist.Flags,
-			Additional: fsist.Additional,
+			Flags:         fsist.Flags,
+			Additional:    fsist.Additional,
+			Status:        instance.Status(fsist),
+			StatusMessage: fsist.StatusMessage,
+			Renewals:      fsist.Renewals,
 		})
 	}
 
 	return &Challenge{
-		Id:         req.GetId(),
-		Scenario:   fschall.Scenario,
-		Timeout:    toPBDuration(fschall.Timeout),
-		Until:      toPBTimestamp(fschall.Until),
-		Instances:  oists,
-		Additional: fschall.Additional,
-		Min:        fschall.Min,
-		Max:        fschall.Max,
+		Id:                req.GetId(),
+		Scenario:          fschall.Scenario,
+		Timeout:           toPBDuration(fschall.Timeout),
+		Until:             toPBTimestamp(fschall.Until),
+		Instances:         oists,
+		Additional:        fschall.Additional,
+		Min:               fschall.Min,
+		Max:               fschall.Max,
+		Schedule:          toPBSchedule(fschall.Schedule),
+		Autoscale:         fschall.Autoscale,
+		Weight:            fschall.Weight,
+		Breaker:           toPBBreaker(ctx, req.GetId()),
+		PoolMaxAge:        toPBDuration(fschall.PoolMaxAge),
+		Variants:          toPBVariants(fschall.Variants),
+		ExcludePausedTime: fschall.ExcludePausedTime,
+		SourceQuota:       fschall.SourceQuota,
+		Sharing:           SharingMode(SharingMode_value[fschall.Sharing]),
+		GroupSize:         fschall.GroupSize,
+		MaxRenewals:       fschall.MaxRenewals,
+		MaxLifetime:       toPBDuration(fschall.MaxLifetime),
+		RenewWindow:       toPBDuration(fschall.RenewWindow),
 	}, nil
 }
 
@@ -182,3 +202,53 @@ func toPBTimestamp(t *time.Time) *timestamppb.Timestamp {
 	}
 	return timestamppb.New(*t)
 }
+
+func toPBSchedule(schedule []pool.Window) []*PoolWindow {
+	if len(schedule) == 0 {
+		return nil
+	}
+	pbs := make([]*PoolWindow, 0, len(schedule))
+	for _, w := range schedule {
+		pbs = append(pbs, &PoolWindow{
+			From: timestamppb.New(w.From),
+			To:   timestamppb.New(w.To),
+			Min:  w.Min,
+			Max:  w.Max,
+		})
+	}
+	return pbs
+}
+
+func toPBVariants(variants []pool.Variant) []*PoolVariant {
+	if len(variants) == 0 {
+		return nil
+	}
+	pbs := make([]*PoolVariant, 0, len(variants))
+	for _, v := range variants {
+		pbs = append(pbs, &PoolVariant{
+			Name:       v.Name,
+			Additional: v.Additional,
+			Min:        v.Min,
+			Max:        v.Max,
+		})
+	}
+	return pbs
+}
+
+func toPBBreaker(ctx context.Context, challengeID string) *PoolBreaker {
+	state, failures, retryAt, err := instance.Breaker(ctx, challengeID)
+	if err != nil {
+		global.Log().Error(ctx, "reading pool circuit breaker",
+			zap.Error(err),
+		)
+		return nil
+	}
+	pbb := &PoolBreaker{
+		State:    BreakerState(state),
+		Failures: int64(failures),
+	}
+	if !retryAt.IsZero() {
+		pbb.RetryAt = timestamppb.New(retryAt)
+	}
+	return pbb
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/ctfer-io/chall-manager/api/v1/instance"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
@@ -38,6 +39,26 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 	if err := common.CheckPooler(um.GetPaths(), req.GetMin(), req.GetMax()); err != nil {
 		return nil, err
 	}
+	schedule := toSchedule(req.GetSchedule())
+	if err := common.CheckSchedule(um.GetPaths(), schedule); err != nil {
+		return nil, err
+	}
+	if err := common.CheckWeight(um.GetPaths(), req.GetWeight()); err != nil {
+		return nil, err
+	}
+	if err := common.CheckPoolMaxAge(um.GetPaths(), req.GetPoolMaxAge()); err != nil {
+		return nil, err
+	}
+	if err := common.CheckSourceQuota(um.GetPaths(), req.GetSourceQuota()); err != nil {
+		return nil, err
+	}
+	if err := common.CheckRenewal(um.GetPaths(), req.GetMaxRenewals(), req.GetMaxLifetime(), req.GetRenewWindow()); err != nil {
+		return nil, err
+	}
+	variants := toVariants(req.GetVariants())
+	if err := common.CheckVariants(um.GetPaths(), variants); err != nil {
+		return nil, err
+	}
 
 	// 1. Lock R TOTW
 	span.AddEvent("lock TOTW")
@@ -150,6 +171,60 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 	if slices.Contains(um.GetPaths(), "max") {
 		fschall.Max = req.GetMax()
 	}
+	if slices.Contains(um.GetPaths(), "schedule") {
+		fschall.Schedule = schedule
+	}
+	if slices.Contains(um.GetPaths(), "autoscale") {
+		fschall.Autoscale = req.GetAutoscale()
+	}
+	if slices.Contains(um.GetPaths(), "exclude_paused_time") {
+		fschall.ExcludePausedTime = req.GetExcludePausedTime()
+	}
+	if slices.Contains(um.GetPaths(), "source_quota") {
+		fschall.SourceQuota = req.GetSourceQuota()
+	}
+	if slices.Contains(um.GetPaths(), "sharing") {
+		fschall.Sharing = req.GetSharing().String()
+	}
+	if slices.Contains(um.GetPaths(), "group_size") {
+		fschall.GroupSize = req.GetGroupSize()
+	}
+	if slices.Contains(um.GetPaths(), "max_renewals") {
+		fschall.MaxRenewals = req.GetMaxRenewals()
+	}
+	if slices.Contains(um.GetPaths(), "max_lifetime") {
+		fschall.MaxLifetime = toDuration(req.GetMaxLifetime())
+	}
+	if slices.Contains(um.GetPaths(), "renew_window") {
+		fschall.RenewWindow = toDuration(req.GetRenewWindow())
+	}
+	if slices.Contains(um.GetPaths(), "weight") {
+		fschall.Weight = req.GetWeight()
+	}
+	if slices.Contains(um.GetPaths(), "pool_max_age") {
+		fschall.PoolMaxAge = toDuration(req.GetPoolMaxAge())
+	}
+	if slices.Contains(um.GetPaths(), "variants") {
+		fschall.Variants = variants
+	}
+
+	// Sharing can change along a single field, so check the resulting one,
+	// and that instances already shared comply with it
+	if slices.Contains(um.GetPaths(), "sharing") || slices.Contains(um.GetPaths(), "group_size") {
+		if err := common.CheckSharing(um.GetPaths(), fschall.Sharing, fschall.GroupSize); err != nil {
+			return nil, err
+		}
+		claims, err := instance.CountClaims(fschall.ID)
+		if err != nil {
+			logger.Error(ctx, "counting instances claims",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		if err := common.CheckShares(fschall, claims); err != nil {
+			return nil, err
+		}
+	}
 
 	// XXX a different scenario reference is not sufficient as the additional can guide variability
 	// (e.g., generic scenario into others paths that might fail)
@@ -195,12 +270,37 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		return nil, errs.ErrInternalNoSub
 	}
 
-	delta := pool.NewDelta(fschall.Min, fschall.Max, int64(len(claimed)), int64(len(pooled)))
+	// Resize the default pool, variant pools are reconciled once updated.
+	// Pooled instances of outdated variants are deleted as no longer matching.
+	claimedPools, _, err := instance.SplitPools(fschall, claimed)
+	if err != nil {
+		logger.Error(ctx, "splitting claimed instances per pool",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	pooledPools, outdated, err := instance.SplitPools(fschall, pooled)
+	if err != nil {
+		logger.Error(ctx, "splitting pooled instances per pool",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	minVal, maxVal := instance.PoolBounds(ctx, fschall, time.Now())
+	delta := pool.NewDelta(minVal, maxVal, int64(len(claimedPools[""])), int64(len(pooledPools[""])))
 	size := len(ists)
 
+	toDelete := slices.Concat(pooledPools[""][:delta.Delete], outdated)
+	toUpdate := pooledPools[""][delta.Delete:]
+	for variant, identities := range pooledPools {
+		if variant != "" {
+			toUpdate = append(toUpdate, identities...)
+		}
+	}
+
 	logger.Debug(ctx, "delta",
-		zap.Int64("min", fschall.Min),
-		zap.Int64("max", fschall.Max),
+		zap.Int64("min", minVal),
+		zap.Int64("max", maxVal),
 		zap.Int("claimed", len(claimed)),
 		zap.Int("pooled", len(pooled)),
 		zap.Int("size", size),
@@ -214,7 +314,7 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 	cerr := make(chan error, size)
 	clm := make(chan string, len(claimed))
 	for _, identity := range claimed {
-		sourceID, err := fs.LookupClaim(req.GetId(), identity)
+		sourceIDs, err := fs.LookupClaims(req.GetId(), identity)
 		if err != nil {
 			// No error should happen as the instance is supposed to be claimed.
 			// Send it over the chan in the work group to avoid waiting indefinitely.
@@ -227,12 +327,12 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		work.Go(func() {
 			// Track span of loading stack
 			ctx, span := global.Tracer.Start(ctx, "updating-instance", trace.WithAttributes(
-				attribute.String("source_id", sourceID),
+				attribute.StringSlice("source_ids", sourceIDs),
 				attribute.String("identity", identity),
 			))
 			defer span.End()
 
-			ctx = global.WithSourceID(ctx, sourceID)
+			ctx = global.WithSourceID(ctx, sourceIDs[0])
 			ctx = global.WithIdentity(ctx, identity)
 
 			logger.Debug(ctx, "updating running instance",
@@ -268,7 +368,7 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 			}
 
 			// 8.c. Mirror instance's "until" based on the challenge
-			fsist.Until = common.ComputeUntil(fschall.Until, fschall.Timeout)
+			fsist.Until = common.InstanceUntil(fschall, fsist.Since)
 
 			// 8.d. If scenario is not nil, update it
 			scn := fschall.Scenario
@@ -290,7 +390,7 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 			ferr := fsist.Save()
 
 			// (Re-)claim the instance (e.g. can be another one with recreate)
-			lerr := fsist.Claim(sourceID)
+			lerr := fsist.Claim(sourceIDs...)
 
 			// TODO add meter for live-updates
 
@@ -300,7 +400,7 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 			}
 
 			// (Re-)claim the instance (e.g. can be another one with recreate)
-			if err := fsist.Claim(sourceID); err != nil {
+			if err := fsist.Claim(sourceIDs...); err != nil {
 				cerr <- err
 			}
 
@@ -319,6 +419,9 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 			}
 
 			logger.Debug(ctx, "updated running instance")
+			for _, sourceID := range sourceIDs {
+				common.EmitInstance(ctx, events.TypeInstanceUpdated, fsist, sourceID)
+			}
 
 			// 8.e. Unlock RW instance
 			//      -> defered after 8.a. (fault-tolerance)
@@ -327,19 +430,7 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		})
 	}
 
-	// Create new instances if there is no until configured or
-	// current calls happens before this until date.
-	if fschall.Until == nil || time.Now().Before(*fschall.Until) {
-		for range delta.Create {
-			logger.Debug(ctx, "spinning up instance in pool")
-
-			// The pool will spin instances and make them available ASAP,
-			// but we don't have the time to wait for it now.
-			go instance.SpinUp(ctx, req.GetId())
-		}
-	}
-
-	for _, identity := range pooled[:delta.Delete] {
+	for _, identity := range toDelete {
 		work.Go(func() {
 			ctx, span := global.Tracer.Start(ctx, "delete-instance", trace.WithAttributes(
 				attribute.String("identity", identity),
@@ -385,7 +476,7 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 	}
 
 	// Update iif required to do so, elseway do nothing
-	for _, identity := range pooled[delta.Delete:] {
+	for _, identity := range toUpdate {
 		work.Go(func() {
 			ctx, span := global.Tracer.Start(ctx, "update-instance", trace.WithAttributes(
 				attribute.String("identity", identity),
@@ -454,12 +545,24 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 	}
 
 	logger.Info(ctx, "challenge updated successfully")
+	common.EmitChallenge(ctx, events.TypeChallengeUpdated, req.GetId())
+
+	// The scenario might have been fixed, let the pool spin-ups resume
+	if err := instance.ResetBreaker(ctx, req.GetId()); err != nil {
+		logger.Error(ctx, "resetting pool circuit breaker",
+			zap.Error(err),
+		)
+	}
+
+	// Fill the pools once the challenge lock is released
+	if delta.Create != 0 || len(fschall.Variants) != 0 {
+		instance.RequestReconcile(ctx, req.GetId())
+	}
 
 	close(clm)
 	oists := make([]*instance.Instance, 0, len(claimed))
 	for identity := range clm {
-		sourceID, _ := fs.LookupClaim(req.Id, identity)
-		ctx := global.WithSourceID(ctx, sourceID)
+		ctx := global.WithIdentity(ctx, identity)
 
 		fsist, err := fs.LoadInstance(req.GetId(), identity)
 		if err != nil {
@@ -473,32 +576,52 @@ func (store *Store) UpdateChallenge(ctx context.Context, req *UpdateChallengeReq
 		if fsist.Until != nil {
 			until = timestamppb.New(*fsist.Until)
 		}
-		oists = append(oists, &instance.Instance{
-			ChallengeId:    req.GetId(),
-			SourceId:       sourceID,
-			Since:          timestamppb.New(fsist.Since),
-			LastRenew:      timestamppb.New(fsist.LastRenew),
-			Until:          until,
-			ConnectionInfo: fsist.ConnectionInfo,
-			Flag: func() *string { // kept for retrocompatibility enough time for public migration
-				if len(fsist.Flags) == 1 {
-					return &fsist.Flags[0]
-				}
-				return nil
-			}(),
-			Flags:      fsist.Flags,
-			Additional: fsist.Additional,
-		})
+		// A shared instance is listed for each of its sources
+		sourceIDs, _ := fs.LookupClaims(req.Id, identity)
+		for _, sourceID := range sourceIDs {
+			oists = append(oists, &instance.Instance{
+				ChallengeId:    req.GetId(),
+				SourceId:       sourceID,
+				Since:          timestamppb.New(fsist.Since),
+				LastRenew:      timestamppb.New(fsist.LastRenew),
+				Until:          until,
+				ConnectionInfo: fsist.ConnectionInfo,
+				Flag: func() *string { // kept for retrocompatibility enough time for public migration
+					if len(fsist.Flags) == 1 {
+						return &fsist.Flags[0]
+					}
+					return nil
+				}(),
+				Flags:         fsist.Flags,
+				Additional:    fsist.Additional,
+				Status:        instance.Status(fsist),
+				StatusMessage: fsist.StatusMessage,
+				Renewals:      fsist.Renewals,
+			})
+		}
 	}
 
 	return &Challenge{
-		Id:         req.GetId(),
-		Scenario:   fschall.Scenario,
-		Additional: fschall.Additional,
-		Min:        fschall.Min,
-		Max:        fschall.Max,
-		Timeout:    toPBDuration(fschall.Timeout),
-		Until:      toPBTimestamp(fschall.Until),
-		Instances:  oists,
+		Id:                req.GetId(),
+		Scenario:          fschall.Scenario,
+		Additional:        fschall.Additional,
+		Min:               fschall.Min,
+		Max:               fschall.Max,
+		Schedule:          toPBSchedule(fschall.Schedule),
+		Autoscale:         fschall.Autoscale,
+		Weight:            fschall.Weight,
+		Breaker:           toPBBreaker(ctx, req.GetId()),
+		PoolMaxAge:        toPBDuration(fschall.PoolMaxAge),
+		Variants:          toPBVariants(fschall.Variants),
+		ExcludePausedTime: fschall.ExcludePausedTime,
+		SourceQuota:       fschall.SourceQuota,
+		Sharing:           SharingMode(SharingMode_value[fschall.Sharing]),
+		GroupSize:         fschall.GroupSize,
+		MaxRenewals:       fschall.MaxRenewals,
+		MaxLifetime:       toPBDuration(fschall.MaxLifetime),
+		RenewWindow:       toPBDuration(fschall.RenewWindow),
+		Timeout:           toPBDuration(fschall.Timeout),
+		Until:             toPBTimestamp(fschall.Until),
+		Instances:         oists,
 	}, nil
 }
@@ -2,15 +2,19 @@ package common
 
 import (
 	"fmt"
+	"maps"
 	"slices"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/pool"
 )
 
 // CheckPooler looks into update mask paths if the pooler bondaries are coherent.
@@ -66,6 +70,375 @@ func CheckPooler(paths []string, min, max int64) error {
 	return st.Err()
 }
 
+// CheckSchedule looks into update mask paths if the pooler schedule windows are
+// coherent, i.e. ordered in time, with pooler boundaries, and not overlapping.
+// If incoherent, returns a non-nil error the business layer can return.
+func CheckSchedule(paths []string, schedule []pool.Window) error {
+	if !slices.Contains(paths, "schedule") {
+		return nil
+	}
+
+	fv := []*errdetails.BadRequest_FieldViolation{}
+	for i, w := range schedule {
+		field := fmt.Sprintf("schedule[%d]", i)
+		if !w.From.Before(w.To) {
+			fv = append(fv, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Reason:      "INVERTED_WINDOW",
+				Description: "Window must start before it ends.",
+			})
+		}
+		if w.Min < 0 || w.Max < 0 {
+			fv = append(fv, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Reason:      "MUST_BE_POSITIVE",
+				Description: "Window boundaries must be positive integers.",
+			})
+		}
+		if w.Max > 0 && w.Min > w.Max {
+			fv = append(fv, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Reason:      "INVERTED_BOUNDARIES",
+				Description: "When an upper bound is defined, minimum cannot exceed maximum.",
+			})
+		}
+		for j, o := range schedule[:i] {
+			if w.From.Before(o.To) && o.From.Before(w.To) {
+				fv = append(fv, &errdetails.BadRequest_FieldViolation{
+					Field:       field,
+					Reason:      "OVERLAPPING_WINDOWS",
+					Description: fmt.Sprintf("Window overlaps with schedule[%d].", j),
+				})
+			}
+		}
+	}
+	if len(fv) == 0 {
+		return nil
+	}
+
+	st, err := status.New(codes.InvalidArgument, "Pooler schedule is invalid.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: errs.ReasonChallengeSchedule,
+			Domain: errs.Domain,
+			Metadata: map[string]string{
+				"windows": fmt.Sprintf("%d", len(schedule)),
+			},
+		},
+		&errdetails.BadRequest{
+			FieldViolations: fv,
+		},
+	)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", err)
+	}
+	return st.Err()
+}
+
+// CheckVariants looks into update mask paths if the pooler variants are
+// coherent, i.e. uniquely named, with distinct non-empty additional values and
+// pooler boundaries.
+// If incoherent, returns a non-nil error the business layer can return.
+func CheckVariants(paths []string, variants []pool.Variant) error {
+	if !slices.Contains(paths, "variants") {
+		return nil
+	}
+
+	fv := []*errdetails.BadRequest_FieldViolation{}
+	for i, v := range variants {
+		field := fmt.Sprintf("variants[%d]", i)
+		if v.Name == "" {
+			fv = append(fv, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Reason:      "MISSING_NAME",
+				Description: "Variant must be named.",
+			})
+		}
+		if len(v.Additional) == 0 {
+			fv = append(fv, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Reason:      "MISSING_ADDITIONAL",
+				Description: "Variant must define additional values, elseway it is the default pool.",
+			})
+		}
+		if v.Min < 0 || v.Max < 0 {
+			fv = append(fv, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Reason:      "MUST_BE_POSITIVE",
+				Description: "Variant boundaries must be positive integers.",
+			})
+		}
+		if v.Max > 0 && v.Min > v.Max {
+			fv = append(fv, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Reason:      "INVERTED_BOUNDARIES",
+				Description: "When an upper bound is defined, minimum cannot exceed maximum.",
+			})
+		}
+		for j, o := range variants[:i] {
+			if v.Name == o.Name {
+				fv = append(fv, &errdetails.BadRequest_FieldViolation{
+					Field:       field,
+					Reason:      "DUPLICATED_NAME",
+					Description: fmt.Sprintf("Variant has the same name as variants[%d].", j),
+				})
+			}
+			if maps.Equal(v.Additional, o.Additional) {
+				fv = append(fv, &errdetails.BadRequest_FieldViolation{
+					Field:       field,
+					Reason:      "DUPLICATED_ADDITIONAL",
+					Description: fmt.Sprintf("Variant has the same additional values as variants[%d].", j),
+				})
+			}
+		}
+	}
+	if len(fv) == 0 {
+		return nil
+	}
+
+	st, err := status.New(codes.InvalidArgument, "Pooler variants are invalid.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: errs.ReasonChallengeVariants,
+			Domain: errs.Domain,
+			Metadata: map[string]string{
+				"variants": fmt.Sprintf("%d", len(variants)),
+			},
+		},
+		&errdetails.BadRequest{
+			FieldViolations: fv,
+		},
+	)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", err)
+	}
+	return st.Err()
+}
+
+// CheckWeight looks into update mask paths if the capacity budget weight is
+// positive. If not, returns a non-nil error the business layer can return.
+func CheckWeight(paths []string, weight int64) error {
+	if !slices.Contains(paths, "weight") || weight >= 0 {
+		return nil
+	}
+
+	st, err := status.New(codes.InvalidArgument, "Weight is invalid.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: errs.ReasonChallengeWeight,
+			Domain: errs.Domain,
+			Metadata: map[string]string{
+				"weight": fmt.Sprintf("%d", weight),
+			},
+		},
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{
+					Field:       "weight",
+					Reason:      "MUST_BE_POSITIVE",
+					Description: "Weight must be a positive integer.",
+				},
+			},
+		},
+	)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", err)
+	}
+	return st.Err()
+}
+
+// CheckSourceQuota looks into update mask paths if the source quota is
+// positive. If not, returns a non-nil error the business layer can return.
+func CheckSourceQuota(paths []string, quota int64) error {
+	if !slices.Contains(paths, "source_quota") || quota >= 0 {
+		return nil
+	}
+
+	st, err := status.New(codes.InvalidArgument, "Source quota is invalid.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: errs.ReasonChallengeSourceQuota,
+			Domain: errs.Domain,
+			Metadata: map[string]string{
+				"source_quota": fmt.Sprintf("%d", quota),
+			},
+		},
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{
+					Field:       "source_quota",
+					Reason:      "MUST_BE_POSITIVE",
+					Description: "Source quota must be a positive integer.",
+				},
+			},
+		},
+	)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", err)
+	}
+	return st.Err()
+}
+
+// CheckSharing looks into update mask paths if the sharing mode is consistent
+// with the group size, i.e. groups of at least 2 sources. As either can be
+// updated alone, they must be the resulting ones, e.g. merged with the stored
+// ones. If not, returns a non-nil error the business layer can return.
+func CheckSharing(paths []string, sharing string, groupSize int64) error {
+	fv := []*errdetails.BadRequest_FieldViolation{}
+	if slices.Contains(paths, "group_size") && groupSize < 0 {
+		fv = append(fv, &errdetails.BadRequest_FieldViolation{
+			Field:       "group_size",
+			Reason:      "MUST_BE_POSITIVE",
+			Description: "Group size must be a positive integer.",
+		})
+	}
+	if (slices.Contains(paths, "sharing") || slices.Contains(paths, "group_size")) &&
+		sharing == fs.SharingGroups && groupSize < 2 {
+		fv = append(fv, &errdetails.BadRequest_FieldViolation{
+			Field:       "group_size",
+			Reason:      "TOO_SMALL",
+			Description: "Group size must be at least 2 to share instances in groups.",
+		})
+	}
+	if len(fv) == 0 {
+		return nil
+	}
+
+	st, err := status.New(codes.InvalidArgument, "Sharing is invalid.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: errs.ReasonChallengeSharing,
+			Domain: errs.Domain,
+			Metadata: map[string]string{
+				"sharing":    sharing,
+				"group_size": fmt.Sprintf("%d", groupSize),
+			},
+		},
+		&errdetails.BadRequest{
+			FieldViolations: fv,
+		},
+	)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", err)
+	}
+	return st.Err()
+}
+
+// CheckShares looks if the instances of a challenge are claimed by no more
+// sources than its sharing mode allows, given their number of claims per
+// identity, e.g. once the sharing mode or group size changed. If not, returns
+// a non-nil error the business layer can return.
+func CheckShares(fschall *fs.Challenge, claims map[string]int) error {
+	pv := []*errdetails.PreconditionFailure_Violation{}
+	for _, identity := range slices.Sorted(maps.Keys(claims)) {
+		if fschall.Holds(claims[identity]) {
+			continue
+		}
+		pv = append(pv, &errdetails.PreconditionFailure_Violation{
+			Type:        "SHARED_INSTANCE",
+			Subject:     identity,
+			Description: fmt.Sprintf("Instance is claimed by %d sources, more than the sharing mode allows.", claims[identity]),
+		})
+	}
+	if len(pv) == 0 {
+		return nil
+	}
+
+	st, err := status.New(codes.FailedPrecondition, "Instances are shared by too many sources.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: errs.ReasonChallengeSharing,
+			Domain: errs.Domain,
+			Metadata: map[string]string{
+				"sharing":    fschall.Sharing,
+				"group_size": fmt.Sprintf("%d", fschall.GroupSize),
+			},
+		},
+		&errdetails.PreconditionFailure{
+			Violations: pv,
+		},
+	)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", err)
+	}
+	return st.Err()
+}
+
+// CheckRenewal looks into update mask paths if the renewal policy is coherent,
+// i.e. a positive cap on renewals and strictly positive durations. If not,
+// returns a non-nil error the business layer can return.
+func CheckRenewal(paths []string, maxRenewals int64, maxLifetime, renewWindow *durationpb.Duration) error {
+	fv := []*errdetails.BadRequest_FieldViolation{}
+	metadata := map[string]string{}
+	if slices.Contains(paths, "max_renewals") && maxRenewals < 0 {
+		fv = append(fv, &errdetails.BadRequest_FieldViolation{
+			Field:       "max_renewals",
+			Reason:      "MUST_BE_POSITIVE",
+			Description: "Maximum renewals must be a positive integer.",
+		})
+		metadata["max_renewals"] = fmt.Sprintf("%d", maxRenewals)
+	}
+	if slices.Contains(paths, "max_lifetime") && maxLifetime != nil && maxLifetime.AsDuration() <= 0 {
+		fv = append(fv, &errdetails.BadRequest_FieldViolation{
+			Field:       "max_lifetime",
+			Reason:      "MUST_BE_POSITIVE",
+			Description: "Maximum lifetime must be a strictly positive duration.",
+		})
+		metadata["max_lifetime"] = maxLifetime.AsDuration().String()
+	}
+	if slices.Contains(paths, "renew_window") && renewWindow != nil && renewWindow.AsDuration() <= 0 {
+		fv = append(fv, &errdetails.BadRequest_FieldViolation{
+			Field:       "renew_window",
+			Reason:      "MUST_BE_POSITIVE",
+			Description: "Renewal window must be a strictly positive duration.",
+		})
+		metadata["renew_window"] = renewWindow.AsDuration().String()
+	}
+	if len(fv) == 0 {
+		return nil
+	}
+
+	st, err := status.New(codes.InvalidArgument, "Renewal policy is invalid.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   errs.ReasonChallengeRenewal,
+			Domain:   errs.Domain,
+			Metadata: metadata,
+		},
+		&errdetails.BadRequest{
+			FieldViolations: fv,
+		},
+	)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", err)
+	}
+	return st.Err()
+}
+
+// CheckPoolMaxAge looks into update mask paths if the pool max age is strictly
+// positive. If not, returns a non-nil error the business layer can return.
+func CheckPoolMaxAge(paths []string, maxAge *durationpb.Duration) error {
+	if !slices.Contains(paths, "pool_max_age") || maxAge == nil || maxAge.AsDuration() > 0 {
+		return nil
+	}
+
+	st, err := status.New(codes.InvalidArgument, "Pool max age is invalid.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: errs.ReasonChallengePoolMaxAge,
+			Domain: errs.Domain,
+			Metadata: map[string]string{
+				"pool_max_age": maxAge.AsDuration().String(),
+			},
+		},
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{
+					Field:       "pool_max_age",
+					Reason:      "MUST_BE_POSITIVE",
+					Description: "Pool max age must be a strictly positive duration.",
+				},
+			},
+		},
+	)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", err)
+	}
+	return st.Err()
+}
+
 func CheckUpdateMask(fm *fieldmaskpb.FieldMask, m proto.Message) error {
 	if fm == nil || fm.IsValid(m) {
 		return nil
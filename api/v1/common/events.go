@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+var emitter *events.Emitter
+
+// SetupEvents builds the sinks of the configured events stream, if any.
+// It must be called once on startup, before serving the API.
+func SetupEvents() error {
+	if len(global.Conf.Events.Sinks) == 0 {
+		return nil
+	}
+	sinks := make([]events.Sink, 0, len(global.Conf.Events.Sinks))
+	for _, raw := range global.Conf.Events.Sinks {
+		sink, err := events.NewSink(raw)
+		if err != nil {
+			for _, sink := range sinks {
+				_ = sink.Close()
+			}
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	emitter = events.NewEmitter(global.Conf.Events.Source, sinks...)
+	return nil
+}
+
+// CloseEvents publishes the remaining events then closes the sinks.
+func CloseEvents() error {
+	if emitter == nil {
+		return nil
+	}
+	return emitter.Close()
+}
+
+// EmitChallenge publishes a challenge event, if an events stream is
+// configured.
+func EmitChallenge(ctx context.Context, typ, challengeID string) {
+	if emitter == nil {
+		return
+	}
+	emitter.Emit(ctx, typ, challengeID, &events.ChallengeData{
+		ChallengeID: challengeID,
+	})
+}
+
+// EmitInstance publishes an instance event for a source, if an events stream
+// is configured.
+func EmitInstance(ctx context.Context, typ string, fsist *fs.Instance, sourceID string) {
+	if emitter == nil {
+		return
+	}
+	emitter.Emit(ctx, typ, fsist.ChallengeID+"/"+sourceID, instanceData(fsist, sourceID))
+}
+
+// EmitTransfer publishes the transfer of an instance from a source to
+// another, if an events stream is configured.
+func EmitTransfer(ctx context.Context, fsist *fs.Instance, fromSourceID, toSourceID string) {
+	if emitter == nil {
+		return
+	}
+	data := instanceData(fsist, toSourceID)
+	data.FromSourceID = fromSourceID
+	emitter.Emit(ctx, events.TypeInstanceTransferred, fsist.ChallengeID+"/"+toSourceID, data)
+}
+
+func instanceData(fsist *fs.Instance, sourceID string) *events.InstanceData {
+	var until *time.Time
+	if fsist.Until != nil {
+		u := *fsist.Until
+		until = &u
+	}
+	status := fsist.Status
+	if status == "" {
+		status = fs.StatusReady // saved before statuses existed
+	}
+	return &events.InstanceData{
+		ChallengeID: fsist.ChallengeID,
+		SourceID:    sourceID,
+		Status:      status,
+		Until:       until,
+	}
+}
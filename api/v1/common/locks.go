@@ -19,3 +19,19 @@ func LockChallenge(ctx context.Context, challengeID string) (lock.RWLock, error)
 func LockInstance(ctx context.Context, challengeID, identity string) (lock.RWLock, error) {
 	return lock.NewRWLock(ctx, filepath.Join("chall", fs.Hash(challengeID), "src", fs.Hash(identity)))
 }
+
+func LockSource(ctx context.Context, sourceID string) (lock.RWLock, error) {
+	return lock.NewRWLock(ctx, filepath.Join("src", fs.Hash(sourceID)))
+}
+
+func LockShares(ctx context.Context, challengeID string) (lock.RWLock, error) {
+	return lock.NewRWLock(ctx, filepath.Join("chall", fs.Hash(challengeID), "share"))
+}
+
+func LockBudget(ctx context.Context) (lock.RWLock, error) {
+	return lock.NewRWLock(ctx, "budget")
+}
+
+func LockPool(ctx context.Context, challengeID string) (lock.RWLock, error) {
+	return lock.NewRWLock(ctx, filepath.Join("chall", fs.Hash(challengeID), "pool"))
+}
@@ -14,6 +14,12 @@ var (
 
 	instancesUDCounter     metric.Int64UpDownCounter
 	instancesUDCounterOnce sync.Once
+
+	poolTargetGauge     metric.Int64Gauge
+	poolTargetGaugeOnce sync.Once
+
+	poolBreakerGauge     metric.Int64Gauge
+	poolBreakerGaugeOnce sync.Once
 )
 
 func ChallengesUDCounter() metric.Int64UpDownCounter {
@@ -42,6 +48,32 @@ func InstancesUDCounter() metric.Int64UpDownCounter {
 	return instancesUDCounter
 }
 
+func PoolTargetGauge() metric.Int64Gauge {
+	poolTargetGaugeOnce.Do(func() {
+		g, err := global.Meter.Int64Gauge("pool.target",
+			metric.WithDescription("The number of instances the autoscaled pool targets"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		poolTargetGauge = g
+	})
+	return poolTargetGauge
+}
+
+func PoolBreakerGauge() metric.Int64Gauge {
+	poolBreakerGaugeOnce.Do(func() {
+		g, err := global.Meter.Int64Gauge("pool.breaker",
+			metric.WithDescription("The state of the pool circuit breaker (0 closed, 1 backoff, 2 open)"),
+		)
+		if err != nil {
+			panic(err)
+		}
+		poolBreakerGauge = g
+	})
+	return poolBreakerGauge
+}
+
 func InstanceAttrs(challID, sourceID string, pool bool) attribute.Set {
 	attrs := []attribute.KeyValue{
 		attribute.String("challenge", challID),
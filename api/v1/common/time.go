@@ -1,6 +1,10 @@
 package common
 
-import "time"
+import (
+	"time"
+
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
 
 // ComputeUntil returns an instance until date based on a challenge until
 // date and the challenge timeout.
@@ -26,3 +30,14 @@ func ComputeUntil(until *time.Time, timeout *time.Duration) *time.Time {
 	}
 	return until
 }
+
+// InstanceUntil returns the until date of an instance created at since, i.e.
+// the one of ComputeUntil capped to the challenge maximum lifetime, if any.
+func InstanceUntil(fschall *fs.Challenge, since time.Time) *time.Time {
+	until := ComputeUntil(fschall.Until, fschall.Timeout)
+	deadline := fschall.Deadline(since)
+	if deadline == nil || (until != nil && until.Before(*deadline)) {
+		return until
+	}
+	return deadline
+}
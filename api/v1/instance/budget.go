@@ -0,0 +1,161 @@
+package instance
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/identity"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+const budgetPrefix = "/chall-manager/budget/"
+
+// budget holds the capacity reserved by instances being deployed, until they
+// are saved on the filesystem hence accounted in the usage.
+// It is only used without etcd, elseway reservations are shared by all
+// replicas through etcd.
+var budget = struct {
+	sync.Mutex
+	reserved int64
+}{}
+
+// ReserveBudget reserves the capacity for a new instance of the challenge
+// in the server-wide budget, if any is configured.
+// The returned release function must be called once the instance is saved on
+// filesystem, or its deployment failed.
+// Returns an [*errs.BudgetExhausted] if there is not enough capacity left.
+func ReserveBudget(ctx context.Context, fschall *fs.Challenge) (release func(), err error) {
+	if global.Conf.Budget <= 0 {
+		return func() {}, nil
+	}
+
+	// Lock RW budget, such that replicas can't reserve the same capacity
+	block, err := common.LockBudget(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := block.RWLock(ctx); err != nil {
+		return nil, err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			global.Log().Error(ctx, "budget RW unlock", zap.Error(err))
+		}
+	}(block)
+
+	used, err := BudgetUsage()
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := reservedBudget(ctx)
+	if err != nil {
+		return nil, err
+	}
+	used += reserved
+
+	units := fschall.Units()
+	if used+units > global.Conf.Budget {
+		return nil, &errs.BudgetExhausted{
+			ChallengeID: fschall.ID,
+			Budget:      global.Conf.Budget,
+			Used:        used,
+			Weight:      units,
+		}
+	}
+	return reserveBudget(ctx, units)
+}
+
+// reservedBudget returns the capacity units reserved by the instances being
+// deployed.
+func reservedBudget(ctx context.Context) (int64, error) {
+	if global.Conf.Etcd.Endpoint == "" {
+		budget.Lock()
+		defer budget.Unlock()
+
+		return budget.reserved, nil
+	}
+
+	res, err := global.GetEtcdManager().Get(ctx, budgetPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	var reserved int64
+	for _, kv := range res.Kvs {
+		units, err := strconv.ParseInt(string(kv.Value), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		reserved += units
+	}
+	return reserved, nil
+}
+
+// reserveBudget reserves capacity units. With etcd, the reservation is bound
+// to the session of the replica, such that it is dropped if the replica dies
+// while deploying.
+func reserveBudget(ctx context.Context, units int64) (func(), error) {
+	if global.Conf.Etcd.Endpoint == "" {
+		budget.Lock()
+		budget.reserved += units
+		budget.Unlock()
+
+		return sync.OnceFunc(func() {
+			budget.Lock()
+			defer budget.Unlock()
+
+			budget.reserved -= units
+		}), nil
+	}
+
+	man := global.GetEtcdManager()
+	sess, _, err := man.GetSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := budgetPrefix + identity.New()
+	if _, err := man.Put(ctx, key, strconv.FormatInt(units, 10), clientv3.WithLease(sess.Lease())); err != nil {
+		return nil, err
+	}
+	return sync.OnceFunc(func() {
+		if _, err := man.Delete(context.WithoutCancel(ctx), key); err != nil {
+			global.Log().Error(ctx, "releasing capacity budget", zap.Error(err))
+		}
+	}), nil
+}
+
+// BudgetUsage returns the capacity units consumed by all instances on the
+// filesystem, both pooled and claimed.
+func BudgetUsage() (int64, error) {
+	challs, err := fs.ListChallenges()
+	if err != nil {
+		return 0, err
+	}
+	var used int64
+	for _, challengeID := range challs {
+		fschall, err := fs.LoadChallenge(challengeID)
+		if err != nil {
+			if _, ok := err.(*errs.ChallengeExist); ok {
+				continue // deleted in the meantime
+			}
+			return 0, err
+		}
+		ists, err := fs.ListInstances(challengeID)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // deleted in the meantime
+			}
+			return 0, err
+		}
+		used += int64(len(ists)) * fschall.Units()
+	}
+	return used, nil
+}
@@ -0,0 +1,284 @@
+package instance
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// bulkOp is an operation on the instance of a source, run with the challenge
+// R lock held. It must release it through unlock once no longer needed.
+type bulkOp func(ctx context.Context, fschall *fs.Challenge, sourceID string, unlock func() error) (*Instance, error)
+
+func (man *Manager) DeleteInstancesBySource(req *DeleteInstancesBySourceRequest, server InstanceManager_DeleteInstancesBySourceServer) error {
+	return bySource(server, req.GetSourceId(), func(ctx context.Context, fschall *fs.Challenge, sourceID string, unlock func() error) (*Instance, error) {
+		return nil, deleteInstance(ctx, fschall, sourceID, unlock)
+	})
+}
+
+func (man *Manager) RenewInstancesBySource(req *RenewInstancesBySourceRequest, server InstanceManager_RenewInstancesBySourceServer) error {
+	return bySource(server, req.GetSourceId(), func(ctx context.Context, fschall *fs.Challenge, sourceID string, unlock func() error) (*Instance, error) {
+		ist, err := renewInstance(ctx, fschall, sourceID)
+		return ist, multierr.Combine(err, unlock())
+	})
+}
+
+func (man *Manager) DeleteInstancesByChallenge(req *DeleteInstancesByChallengeRequest, server InstanceManager_DeleteInstancesByChallengeServer) error {
+	logger := global.Log()
+	// Go on if the client disconnects, not to leave partial states
+	ctx := context.WithoutCancel(server.Context())
+	ctx = global.WithChallengeID(ctx, req.GetChallengeId())
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, req.GetChallengeId())
+	if err != nil {
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(ctx),
+			err,
+		)))
+		return errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(ctx),
+			err,
+		)))
+		return errs.ErrInternalNoSub
+	}
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(ctx); err != nil {
+		logger.Error(ctx, "TOTW R unlock", zap.Error(multierr.Combine(
+			clock.RUnlock(ctx),
+			err,
+		)))
+		return errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge does not exist, return error
+	fschall, err := fs.LoadChallenge(req.GetChallengeId())
+	if err != nil {
+		if err := clock.RUnlock(ctx); err != nil {
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return err
+		}
+		logger.Error(ctx, "loading challenge", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+
+	// 5. List the sources of claimed instances
+	sourceIDs := []string{}
+	ists, err := fs.ListInstances(req.GetChallengeId())
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error(ctx, "listing instances", zap.Error(multierr.Combine(
+			clock.RUnlock(ctx),
+			err,
+		)))
+		return errs.ErrInternalNoSub
+	}
+	for _, identity := range ists {
+		claims, err := fs.LookupClaims(req.GetChallengeId(), identity)
+		if err != nil {
+			if ierr, ok := err.(*errs.InstanceExist); ok && !ierr.Exist {
+				continue // no claim file => in pool
+			}
+			logger.Error(ctx, "looking up for claims", zap.Error(multierr.Combine(
+				clock.RUnlock(ctx),
+				err,
+			)))
+			return errs.ErrInternalNoSub
+		}
+		sourceIDs = append(sourceIDs, claims...)
+	}
+	logger.Info(ctx, "deleting challenge instances",
+		zap.Int("sources", len(sourceIDs)),
+	)
+
+	// 6. Delete them all, the challenge is unlocked once none needs it anymore
+	//    (the "relock" wait group, as QueryChallenge does)
+	qs := common.NewQueryServer[*BulkInstanceResult](server)
+	relock := &sync.WaitGroup{}
+	relock.Add(len(sourceIDs))
+	work := &sync.WaitGroup{}
+	sem := bulkSem()
+	for _, sourceID := range sourceIDs {
+		sem <- struct{}{}
+		work.Go(func() {
+			defer func() { <-sem }()
+
+			err := deleteInstance(ctx, fschall, sourceID, func() error {
+				relock.Done()
+				return nil
+			})
+			sendBulkResult(ctx, qs, fschall.ID, sourceID, nil, err)
+		})
+	}
+	relock.Wait()
+	if err := clock.RUnlock(ctx); err != nil {
+		logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		span.RecordError(err)
+		// don't return now to avoid having working goroutines after request completion (zombies)
+	}
+	work.Wait()
+	return nil
+}
+
+// bySource runs an operation on the instances of a source over all
+// challenges, and streams their outcome.
+// It goes on if the client disconnects, not to leave partial states.
+func bySource(server grpc.ServerStream, sourceID string, op bulkOp) error {
+	logger := global.Log()
+	ctx := context.WithoutCancel(server.Context())
+	ctx = global.WithSourceID(ctx, sourceID)
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Fetch all challenges
+	challs, err := fs.ListChallenges()
+	if err != nil {
+		logger.Error(ctx, "listing challenges", zap.Error(multierr.Combine(
+			err,
+			totw.RUnlock(ctx),
+		)))
+		return errs.ErrInternalNoSub
+	}
+
+	// 3. Create "relock" and "work" wait groups for all challenges, and for each
+	qs := common.NewQueryServer[*BulkInstanceResult](server)
+	relock := &sync.WaitGroup{}
+	relock.Add(len(challs))
+	work := &sync.WaitGroup{}
+	sem := bulkSem()
+	for _, challengeID := range challs {
+		sem <- struct{}{}
+		work.Go(func() {
+			defer func() { <-sem }()
+
+			ctx, span := global.Tracer.Start(ctx, "bulk-challenge", trace.WithAttributes(
+				attribute.String("challenge_id", challengeID),
+			))
+			defer span.End()
+
+			ctx = global.WithChallengeID(ctx, challengeID)
+
+			// 4.a. Lock R challenge
+			clock, err := common.LockChallenge(ctx, challengeID)
+			if err != nil {
+				relock.Done() // release to avoid dead-lock
+				sendBulkResult(ctx, qs, challengeID, sourceID, nil, err)
+				return
+			}
+			if err := clock.RLock(ctx); err != nil {
+				relock.Done() // release to avoid dead-lock
+				sendBulkResult(ctx, qs, challengeID, sourceID, nil, err)
+				return
+			}
+			unlock := sync.OnceValue(func() error {
+				return clock.RUnlock(ctx)
+			})
+			defer func() {
+				if err := unlock(); err != nil {
+					logger.Error(ctx, "challenge R unlock", zap.Error(err))
+				}
+			}()
+
+			// 4.b. Done in the "relock" wait group
+			relock.Done()
+
+			// 4.c. Run the operation on the instance of this source, if any
+			fschall, err := fs.LoadChallenge(challengeID)
+			if err != nil {
+				if _, ok := err.(*errs.ChallengeExist); !ok {
+					sendBulkResult(ctx, qs, challengeID, sourceID, nil, err)
+				}
+				return // deleted in the meantime
+			}
+			ist, err := op(ctx, fschall, sourceID, unlock)
+			if ierr, ok := err.(*errs.InstanceExist); ok && !ierr.Exist {
+				return // no instance was claimed by this source, skip it
+			}
+			sendBulkResult(ctx, qs, challengeID, sourceID, ist, err)
+		})
+	}
+
+	// 5. Once all "relock" done, unlock R TOTW
+	relock.Wait()
+	if err := totw.RUnlock(ctx); err != nil {
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		span.RecordError(err)
+		// don't return now to avoid having working goroutines after request completion (zombies)
+	} else {
+		span.AddEvent("unlocked TOTW")
+	}
+
+	// 6. Once all "work" done, the operation is complete
+	work.Wait()
+	return nil
+}
+
+// bulkSem returns the semaphore bounding the concurrent operations of a bulk
+// request. Without configuration, they are run one at a time.
+func bulkSem() chan struct{} {
+	return make(chan struct{}, max(global.Conf.Bulk.Parallelism, 1))
+}
+
+// sendBulkResult streams the outcome of a bulk operation on an instance.
+// The client may have disconnected, so failing to send is not an error.
+func sendBulkResult(ctx context.Context, qs *common.QueryServer[*BulkInstanceResult], challengeID, sourceID string, ist *Instance, err error) {
+	res := &BulkInstanceResult{
+		ChallengeId: challengeID,
+		SourceId:    sourceID,
+		Success:     err == nil,
+		Instance:    ist,
+	}
+	if err != nil {
+		// Internal errors are logged by the operation, others are expected
+		// (e.g. a refused renewal)
+		global.Log().Debug(ctx, "bulk instance operation failed", zap.Error(err))
+		res.Error = status.Convert(errs.StatusFromError(err)).Message()
+	}
+	if err := qs.SendMsg(res); err != nil {
+		global.Log().Debug(ctx, "sending bulk instance result", zap.Error(err))
+	}
+}
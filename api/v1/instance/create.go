@@ -2,6 +2,7 @@ package instance
 
 import (
 	context "context"
+	"sync"
 	"time"
 
 	"go.opentelemetry.io/otel/metric"
@@ -16,9 +17,12 @@ import (
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
 	"github.com/ctfer-io/chall-manager/pkg/identity"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+	"github.com/ctfer-io/chall-manager/pkg/pool"
 )
 
 func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceRequest) (*Instance, error) {
@@ -154,6 +158,161 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 		return nil, errs.ErrInternalNoSub
 	}
 
+	// Lock RW source if it has a quota, such that concurrent requests can't
+	// exceed it. It is released once the instance is claimed.
+	releaseSource := func() {}
+	if Quota(fschall) > 0 {
+		slock, err := common.LockSource(ctx, req.GetSourceId())
+		if err != nil {
+			if slock.IsCanceled(err) {
+				// If canceled, we need to recover
+				if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+					logger.Error(ctx, "recovering from build source lock", zap.Error(err))
+					return nil, errs.ErrInternalNoSub
+				}
+				return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+			}
+			logger.Error(ctx, "build source lock",
+				zap.Error(multierr.Combine(
+					clock.RUnlock(context.WithoutCancel(ctx)),
+					err,
+				)),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		if err := slock.RWLock(ctx); err != nil {
+			if slock.IsCanceled(err) {
+				// If canceled, we need to recover
+				if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+					logger.Error(ctx, "recovering from source RW lock", zap.Error(err))
+					return nil, errs.ErrInternalNoSub
+				}
+				return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+			}
+			logger.Error(ctx, "source RW lock",
+				zap.Error(multierr.Combine(
+					clock.RUnlock(context.WithoutCancel(ctx)),
+					err,
+				)),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		releaseSource = sync.OnceFunc(func() {
+			if err := slock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "source RW unlock", zap.Error(err))
+			}
+		})
+		defer releaseSource()
+
+		if err := CheckQuota(fschall, req.GetSourceId()); err != nil {
+			if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "unlocking R challenge", zap.Error(err))
+			}
+
+			if _, ok := err.(*errs.QuotaExceeded); ok {
+				logger.Warn(ctx, "source quota exceeded")
+				return nil, err
+			}
+			logger.Error(ctx, "checking source quota", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+	}
+
+	// Lock RW shares if instances are shared, such that concurrent requests
+	// join the same instance rather than deploying one each. It is released
+	// once the instance is claimed.
+	releaseShares := func() {}
+	if fschall.Shared() {
+		shlock, err := common.LockShares(ctx, req.GetChallengeId())
+		if err != nil {
+			if shlock.IsCanceled(err) {
+				// If canceled, we need to recover
+				if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+					logger.Error(ctx, "recovering from build shares lock", zap.Error(err))
+					return nil, errs.ErrInternalNoSub
+				}
+				return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+			}
+			logger.Error(ctx, "build shares lock",
+				zap.Error(multierr.Combine(
+					clock.RUnlock(context.WithoutCancel(ctx)),
+					err,
+				)),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		if err := shlock.RWLock(ctx); err != nil {
+			if shlock.IsCanceled(err) {
+				// If canceled, we need to recover
+				if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+					logger.Error(ctx, "recovering from shares RW lock", zap.Error(err))
+					return nil, errs.ErrInternalNoSub
+				}
+				return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+			}
+			logger.Error(ctx, "shares RW lock",
+				zap.Error(multierr.Combine(
+					clock.RUnlock(context.WithoutCancel(ctx)),
+					err,
+				)),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		releaseShares = sync.OnceFunc(func() {
+			if err := shlock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "shares RW unlock", zap.Error(err))
+			}
+		})
+		defer releaseShares()
+
+		// Join a shared instance if one has room left
+		fsist, err := joinShared(ctx, fschall, req.GetSourceId(), req.GetAdditional())
+		if err != nil {
+			if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "unlocking R challenge", zap.Error(err))
+			}
+			if ctx.Err() != nil {
+				return nil, errs.ErrCanceled
+			}
+			logger.Error(ctx, "joining shared instance", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		if fsist != nil {
+			releaseShares()
+			releaseSource()
+			if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "unlocking R challenge", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			logger.Info(global.WithIdentity(ctx, fsist.Identity), "joined shared instance")
+			common.EmitInstance(ctx, events.TypeInstanceClaimed, fsist, req.GetSourceId())
+
+			var until *timestamppb.Timestamp
+			if fsist.Until != nil {
+				until = timestamppb.New(*fsist.Until)
+			}
+			return &Instance{
+				ChallengeId:    req.GetChallengeId(),
+				SourceId:       req.GetSourceId(),
+				Since:          timestamppb.New(fsist.Since),
+				LastRenew:      timestamppb.New(fsist.LastRenew),
+				Until:          until,
+				ConnectionInfo: fsist.ConnectionInfo,
+				Flag: func() *string { // kept for retrocompatibility enough time for public migration
+					if len(fsist.Flags) == 1 {
+						return &fsist.Flags[0]
+					}
+					return nil
+				}(),
+				Flags:         fsist.Flags,
+				Additional:    fsist.Additional,
+				Status:        Status(fsist),
+				StatusMessage: fsist.StatusMessage,
+				Renewals:      fsist.Renewals,
+			}, nil
+		}
+	}
+
 	// If there are instances in pool, claim one, else deploy
 	ists, err := fs.ListInstances(req.GetChallengeId())
 	if err != nil {
@@ -165,40 +324,92 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 		)
 		return nil, errs.ErrInternalNoSub
 	}
-	pooled := []string{}
+	claimedIsts := []string{}
+	pooledIsts := []string{}
 	for _, ist := range ists {
 		_, err := fs.LookupClaim(req.GetChallengeId(), ist)
 		if err, ok := err.(*errs.InstanceExist); ok && !err.Exist {
 			// no claim file => in pool
-			pooled = append(pooled, ist)
+			pooledIsts = append(pooledIsts, ist)
 			continue
 		}
 		if err != nil {
 			logger.Error(ctx, "looking up for claim",
-				zap.Error(err),
+				zap.Error(multierr.Combine(
+					clock.RUnlock(context.WithoutCancel(ctx)),
+					err,
+				)),
 			)
 			return nil, errs.ErrInternalNoSub
 		}
+		claimedIsts = append(claimedIsts, ist)
 	}
 
-	if len(pooled) != 0 {
-		// We spin one new if there is less in the pool than the minimum requested
-		// AND there is either no maximum defined, or we are under the defined maximum
-		// threshold. -1 because we claim one from the pool, so we don't count it.
-		toSpin := len(pooled)-1 < int(fschall.Min) && (fschall.Max == 0 || len(ists) < int(fschall.Max))
+	// Claim from the pool of the variant matching the additional values, if
+	// any, as its instances need no update. Elseway use the default pool.
+	variant := ""
+	if v, ok := pool.Match(fschall.Variants, req.GetAdditional()); ok {
+		variant = v.Name
+	}
+	claimedPools, _, err := SplitPools(fschall, claimedIsts)
+	if err != nil {
+		logger.Error(ctx, "splitting claimed instances per pool",
+			zap.Error(multierr.Combine(
+				clock.RUnlock(context.WithoutCancel(ctx)),
+				err,
+			)),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	pooledPools, outdated, err := SplitPools(fschall, pooledIsts)
+	if err != nil {
+		logger.Error(ctx, "splitting pooled instances per pool",
+			zap.Error(multierr.Combine(
+				clock.RUnlock(context.WithoutCancel(ctx)),
+				err,
+			)),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	pooled := pooledPools[variant]
 
-		// Start concurrent routine that will refill the pool in exchange of the
-		// one we just claimed, if we are under a defined threshold (i.e. max).
-		// Elseway just spin up one more.
-		if toSpin {
-			go SpinUp(ctx, req.GetChallengeId())
-		}
+	// Only claim a healthy pooled instance. Broken ones are replaced in the
+	// background, once we release the challenge lock.
+	candidate, broken := firstHealthy(ctx, req.GetChallengeId(), pooled)
+	if broken != 0 {
+		logger.Warn(ctx, "unhealthy instances in pool",
+			zap.Int("count", broken),
+		)
+	}
+
+	// Refill the pool in exchange of the instance claimed, if we are under the
+	// pool boundaries. The instance we are about to claim counts as claimed,
+	// and no longer as pooled if it comes from the pool.
+	now := time.Now()
+	if variant == "" {
+		claimDemand(ctx, req.GetChallengeId(), now)
+	}
+	minVal, maxVal := VariantBounds(ctx, fschall, variant, now)
+	numClaimed := int64(len(claimedPools[variant])) + 1
+	numPooled := int64(len(pooled) - broken)
+	if candidate != "" {
+		numPooled--
+	}
+	delta := pool.NewDelta(minVal, maxVal, numClaimed, numPooled+inflightOf(req.GetChallengeId(), variant))
 
+	// Request the pool to be refilled, and broken or outdated instances to be
+	// replaced, as we don't have the time to wait for it now.
+	if delta.Create != 0 || broken != 0 || len(outdated) != 0 {
+		RequestReconcile(ctx, req.GetChallengeId())
+	}
+
+	if candidate != "" {
 		// Claim from pool
-		claimed := pooled[0]
+		claimed := candidate
 		ctx = global.WithIdentity(ctx, claimed)
 		logger.Info(ctx, "claiming instance from pool",
-			zap.Bool("spin-up", toSpin),
+			zap.String("variant", variant),
+			zap.Int64("spin-up", delta.Create),
 		)
 
 		if err := fs.Claim(req.GetChallengeId(), claimed, req.GetSourceId()); err != nil {
@@ -210,6 +421,8 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 			)
 			return nil, errs.ErrInternalNoSub
 		}
+		releaseShares()
+		releaseSource()
 
 		// Lock RW instance
 		ctx = global.WithSourceID(ctx, req.GetSourceId())
@@ -262,10 +475,12 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 			return nil, errs.ErrInternalNoSub
 		}
 
-		// Update times and stack
-		fsist.Until = common.ComputeUntil(fschall.Until, fschall.Timeout)
-		fsist.LastRenew = time.Now()
-		if len(req.GetAdditional()) != 0 {
+		// Update times and stack, the lifetime of the instance starts now.
+		// Variant instances are already deployed with the requested
+		// additional values.
+		fsist.Handover(time.Now())
+		fsist.Until = common.InstanceUntil(fschall, fsist.Since)
+		if variant == "" && len(req.GetAdditional()) != 0 {
 			fsist.Additional = req.GetAdditional()
 			if err := iac.Update(ctx, fschall.Scenario, "", fschall, fsist); err != nil {
 				logger.Error(ctx, "updating pooled instance",
@@ -310,6 +525,7 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 			)
 			return nil, errs.ErrInternalNoSub
 		}
+		common.EmitInstance(ctx, events.TypeInstanceClaimed, fsist, req.GetSourceId())
 
 		// Respond
 		var until *timestamppb.Timestamp
@@ -329,8 +545,11 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 				}
 				return nil
 			}(),
-			Flags:      fsist.Flags,
-			Additional: req.GetAdditional(),
+			Flags:         fsist.Flags,
+			Additional:    req.GetAdditional(),
+			Status:        Status(fsist),
+			StatusMessage: fsist.StatusMessage,
+			Renewals:      fsist.Renewals,
 		}, nil
 	}
 
@@ -339,15 +558,95 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 	ctx = global.WithIdentity(ctx, id)
 	logger.Info(ctx, "creating new instance")
 
-	// No need to refine lock -> instance is unique per the identity.
 	// We MUST NOT release the clock until the instance is up & running,
 	// elseway the challenge could be deleted even if we are working on it.
 
+	// Reserve capacity, released once saved on filesystem as provisioning,
+	// as it is then accounted in the usage
+	release, err := ReserveBudget(ctx, fschall)
+	if err != nil {
+		if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "unlocking R challenge", zap.Error(err))
+		}
+
+		if _, ok := err.(*errs.BudgetExhausted); ok {
+			logger.Warn(ctx, "capacity budget exhausted")
+			return nil, err
+		}
+		logger.Error(ctx, "reserving capacity budget", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer release()
+
+	// Lock RW instance, such that it is not updated nor deleted while provisioning
+	ilock, err := common.LockInstance(ctx, req.GetChallengeId(), id)
+	if err != nil {
+		logger.Error(ctx, "build instance lock",
+			zap.Error(multierr.Combine(
+				clock.RUnlock(context.WithoutCancel(ctx)),
+				err,
+			)),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		if ilock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "recovering from instance RW lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+		}
+		logger.Error(ctx, "instance RW lock",
+			zap.Error(multierr.Combine(
+				clock.RUnlock(context.WithoutCancel(ctx)),
+				err,
+			)),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// Save fsist as provisioning, such that it is known while deploying
+	now = time.Now()
+	fsist := &fs.Instance{
+		Identity:    id,
+		ChallengeID: req.GetChallengeId(),
+		Since:       now,
+		LastRenew:   now,
+		Until:       common.InstanceUntil(fschall, now),
+		Additional:  req.GetAdditional(),
+		Variant:     variant,
+		Status:      fs.StatusProvisioning,
+	}
+	if err := multierr.Combine(
+		fsist.Save(),
+		fsist.Claim(req.GetSourceId()),
+	); err != nil {
+		logger.Error(ctx, "exporting instance information to filesystem",
+			zap.Error(multierr.Combine(
+				clock.RUnlock(context.WithoutCancel(ctx)),
+				err,
+			)),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	release()
+	releaseShares()
+	releaseSource()
+
 	// Spin up
+	start := time.Now()
 	stack, err := iac.NewStack(ctx, fschall, id)
 	if err != nil {
 		logger.Error(ctx, "building new stack",
 			zap.Error(multierr.Combine(
+				fsist.Delete(), // nothing deployed yet
 				clock.RUnlock(context.WithoutCancel(ctx)),
 				err,
 			)),
@@ -357,6 +656,7 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 	if err := iac.Additional(ctx, stack, fschall.Additional, req.GetAdditional()); err != nil {
 		logger.Error(ctx, "configuring additionals on stack",
 			zap.Error(multierr.Combine(
+				fsist.Delete(), // nothing deployed yet
 				clock.RUnlock(context.WithoutCancel(ctx)),
 				err,
 			)),
@@ -368,6 +668,7 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 	if err != nil {
 		logger.Error(ctx, "stack up",
 			zap.Error(multierr.Combine(
+				rollbackInstance(ctx, stack, fsist, "deployment failed"),
 				clock.RUnlock(context.WithoutCancel(ctx)),
 				err,
 			)),
@@ -375,24 +676,23 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 		return nil, err
 	}
 
-	now := time.Now()
-	fsist := &fs.Instance{
-		Identity:    id,
-		ChallengeID: req.GetChallengeId(),
-		Since:       now,
-		LastRenew:   now,
-		Until:       common.ComputeUntil(fschall.Until, fschall.Timeout),
-		Additional:  req.GetAdditional(),
-	}
+	now = time.Now()
+	spinUpDemand(ctx, req.GetChallengeId(), now.Sub(start))
+	fsist.Since = now
+	fsist.LastRenew = now
+	fsist.Until = common.InstanceUntil(fschall, fsist.Since)
+	fsist.SetStatus(fs.StatusReady, "")
 	if err := stack.Export(ctx, sr, fsist); err != nil {
 		logger.Error(ctx, "extracting stack info",
 			zap.Error(multierr.Combine(
+				rollbackInstance(ctx, stack, fsist, "deployment outputs are invalid"),
 				clock.RUnlock(context.WithoutCancel(ctx)),
 				err,
 			)),
 		)
 		return nil, errs.ErrInternalNoSub
 	}
+	awaitReadiness(ctx, fsist)
 
 	// Save fsist
 	if err := fsist.Save(); err != nil {
@@ -404,17 +704,9 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 		)
 		return nil, errs.ErrInternalNoSub
 	}
-	if err := fsist.Claim(req.GetSourceId()); err != nil {
-		logger.Error(ctx, "claiming instance",
-			zap.Error(multierr.Combine(
-				clock.RUnlock(context.WithoutCancel(ctx)),
-				err,
-			)),
-		)
-		return nil, errs.ErrInternalNoSub
-	}
 
 	logger.Info(ctx, "instance created successfully")
+	common.EmitInstance(ctx, events.TypeInstanceCreated, fsist, req.GetSourceId())
 	common.InstancesUDCounter().Add(ctx, 1,
 		metric.WithAttributeSet(common.InstanceAttrs(req.GetChallengeId(), req.GetSourceId(), false)),
 	)
@@ -445,7 +737,27 @@ func (man *Manager) CreateInstance(ctx context.Context, req *CreateInstanceReque
 			}
 			return nil
 		}(),
-		Flags:      fsist.Flags,
-		Additional: req.GetAdditional(),
+		Flags:         fsist.Flags,
+		Additional:    req.GetAdditional(),
+		Status:        Status(fsist),
+		StatusMessage: fsist.StatusMessage,
+		Renewals:      fsist.Renewals,
 	}, nil
 }
+
+// rollbackInstance destroys what has been deployed of an instance that could
+// not be created, then deletes it such that its source can request a new one.
+// If it can't be destroyed, it is kept as failed, with what has been deployed,
+// such that it can be deleted later on.
+func rollbackInstance(ctx context.Context, stack *iac.Stack, fsist *fs.Instance, msg string) error {
+	ctx = context.WithoutCancel(ctx)
+	if err := stack.Down(ctx); err != nil {
+		fsist.SetStatus(fs.StatusFailed, msg)
+		return multierr.Combine(
+			stack.ExportState(ctx, fsist),
+			fsist.Save(),
+			err,
+		)
+	}
+	return fsist.Delete()
+}
@@ -2,6 +2,7 @@ package instance
 
 import (
 	"context"
+	"time"
 
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -12,6 +13,7 @@ import (
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
@@ -104,59 +106,72 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 		return nil, errs.ErrInternalNoSub
 	}
 
+	// 5-9. Delete the instance, it unlocks the challenge once no longer needed
+	return nil, deleteInstance(ctx, fschall, req.GetSourceId(), func() error {
+		return clock.RUnlock(context.WithoutCancel(ctx))
+	})
+}
+
+// deleteInstance deletes the instance of a source, or detaches the source if
+// it shares the instance with others.
+// It must be called with the challenge R lock held, and releases it through
+// unlock once no longer needed, whatever the outcome.
+func deleteInstance(ctx context.Context, fschall *fs.Challenge, sourceID string, unlock func() error) error {
+	logger := global.Log()
+
 	// 5. Lock RW instance
-	ctx = global.WithSourceID(ctx, req.GetSourceId())
-	id, err := fs.FindInstance(req.GetChallengeId(), req.GetSourceId())
+	ctx = global.WithSourceID(ctx, sourceID)
+	id, err := fs.FindInstance(fschall.ID, sourceID)
 	if err != nil {
-		if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		if err := unlock(); err != nil {
 			logger.Error(ctx, "unlocking R challenge", zap.Error(err))
 		}
 
 		if _, ok := err.(*errs.InstanceExist); ok {
-			return nil, err
+			return err
 		}
 
 		logger.Error(ctx, "finding instance",
 			zap.Error(err),
 		)
-		return nil, errs.ErrInternalNoSub
+		return errs.ErrInternalNoSub
 	}
 
 	ctx = global.WithIdentity(ctx, id)
-	ilock, err := common.LockInstance(ctx, req.GetChallengeId(), id)
+	ilock, err := common.LockInstance(ctx, fschall.ID, id)
 	if err != nil {
 		if ilock.IsCanceled(err) {
 			// If canceled, we need to recover
-			if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			if err := unlock(); err != nil {
 				logger.Error(ctx, "recovering from challenge R unlock", zap.Error(err))
-				return nil, errs.ErrInternalNoSub
+				return errs.ErrInternalNoSub
 			}
-			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+			return errs.ErrCanceled // recovery is successful, we can quit safely
 		}
 		logger.Error(ctx, "build challenge lock",
 			zap.Error(multierr.Combine(
-				clock.RUnlock(context.WithoutCancel(ctx)),
+				unlock(),
 				err,
 			)),
 		)
-		return nil, errs.ErrInternalNoSub
+		return errs.ErrInternalNoSub
 	}
 	if err := ilock.RWLock(ctx); err != nil {
 		if ilock.IsCanceled(err) {
 			// If canceled, we need to recover
-			if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			if err := unlock(); err != nil {
 				logger.Error(ctx, "recovering from challenge instance RW lock", zap.Error(err))
-				return nil, errs.ErrInternalNoSub
+				return errs.ErrInternalNoSub
 			}
-			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+			return errs.ErrCanceled // recovery is successful, we can quit safely
 		}
 		logger.Error(ctx, "challenge instance RW lock",
 			zap.Error(multierr.Combine(
-				clock.RUnlock(context.WithoutCancel(ctx)),
+				unlock(),
 				err,
 			)),
 		)
-		return nil, errs.ErrInternalNoSub
+		return errs.ErrInternalNoSub
 	}
 	defer func(lock lock.RWLock) {
 		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
@@ -164,46 +179,107 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 		}
 	}(ilock)
 
-	ists, err := fs.ListInstances(req.GetChallengeId())
+	// 6. Detach the source if it shares the instance with others, the last
+	//    one to leave destroys it
+	claims, err := fs.LookupClaims(fschall.ID, id)
+	if err != nil {
+		logger.Error(ctx, "looking up for claims",
+			zap.Error(multierr.Combine(
+				unlock(),
+				err,
+			)),
+		)
+		return errs.ErrInternalNoSub
+	}
+	if len(claims) > 1 {
+		logger.Info(ctx, "detaching source from shared instance",
+			zap.Int("sources", len(claims)-1),
+		)
+		fsist, err := fs.LoadInstance(fschall.ID, id)
+		if err != nil {
+			logger.Error(ctx, "loading instance",
+				zap.Error(multierr.Combine(
+					unlock(),
+					err,
+				)),
+			)
+			return errs.ErrInternalNoSub
+		}
+		if err := multierr.Combine(
+			fs.Unclaim(fschall.ID, id, sourceID),
+			unlock(),
+		); err != nil {
+			logger.Error(ctx, "detaching source", zap.Error(err))
+			return errs.ErrInternalNoSub
+		}
+		notifyExpired(ctx, fsist, sourceID)
+		common.EmitInstance(ctx, deletedEvent(fsist), fsist, sourceID)
+		return nil
+	}
+
+	ists, err := fs.ListInstances(fschall.ID)
 	if err != nil {
 		logger.Error(ctx, "listing instances",
 			zap.Error(multierr.Combine(
-				clock.RUnlock(context.WithoutCancel(ctx)),
+				unlock(),
 				err,
 			)),
 		)
-		return nil, errs.ErrInternalNoSub
+		return errs.ErrInternalNoSub
 	}
 	pooled := []string{}
 	for _, ist := range ists {
-		sourceID, err := fs.LookupClaim(req.GetChallengeId(), ist)
+		_, err := fs.LookupClaim(fschall.ID, ist)
 		if err, ok := err.(*errs.InstanceExist); ok && !err.Exist {
 			// no claim file => in pool
-			pooled = append(pooled, sourceID)
+			pooled = append(pooled, ist)
 			continue
 		}
 		if err != nil {
 			logger.Error(ctx, "looking up for claim",
-				zap.Error(err),
+				zap.Error(multierr.Combine(
+					unlock(),
+					err,
+				)),
 			)
-			return nil, errs.ErrInternalNoSub
+			return errs.ErrInternalNoSub
 		}
 	}
+	pools, _, err := SplitPools(fschall, ists)
+	if err != nil {
+		logger.Error(ctx, "splitting instances per pool",
+			zap.Error(multierr.Combine(
+				unlock(),
+				err,
+			)),
+		)
+		return errs.ErrInternalNoSub
+	}
+	pooledPools, _, err := SplitPools(fschall, pooled)
+	if err != nil {
+		logger.Error(ctx, "splitting pooled instances per pool",
+			zap.Error(multierr.Combine(
+				unlock(),
+				err,
+			)),
+		)
+		return errs.ErrInternalNoSub
+	}
 
-	if err := clock.RUnlock(context.WithoutCancel(ctx)); err != nil {
-		logger.Error(ctx, "challenge RW unlock",
+	if err := unlock(); err != nil {
+		logger.Error(ctx, "challenge R unlock",
 			zap.Error(err),
 		)
-		return nil, errs.ErrInternalNoSub
+		return errs.ErrInternalNoSub
 	}
 
-	// 6. Pulumi down the instance, delete state+metadata from filesystem
-	fsist, err := fs.LoadInstance(req.GetChallengeId(), id)
+	// 7. Pulumi down the instance, delete state+metadata from filesystem
+	fsist, err := fs.LoadInstance(fschall.ID, id)
 	if err != nil {
 		logger.Error(ctx, "loading instance",
 			zap.Error(err),
 		)
-		return nil, errs.ErrInternalNoSub
+		return errs.ErrInternalNoSub
 	}
 
 	// Reload cache if necessary
@@ -212,34 +288,52 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 		logger.Error(ctx, "creating challenge instance stack",
 			zap.Error(err),
 		)
-		return nil, errs.ErrInternalNoSub
+		return errs.ErrInternalNoSub
 	}
-	if err := stack.Import(ctx, fsist); err != nil {
-		logger.Error(ctx, "unmarshalling Pulumi state",
-			zap.Error(err),
-		)
-		return nil, errs.ErrInternalNoSub
+	if fsist.State != nil { // e.g. failed before anything got deployed
+		if err := stack.Import(ctx, fsist); err != nil {
+			logger.Error(ctx, "unmarshalling Pulumi state",
+				zap.Error(err),
+			)
+			return errs.ErrInternalNoSub
+		}
 	}
 
 	logger.Info(ctx, "deleting instance")
 
+	fsist.SetStatus(fs.StatusDeleting, "")
+	if err := fsist.Save(); err != nil {
+		logger.Error(ctx, "exporting instance information to filesystem",
+			zap.Error(err),
+		)
+		return errs.ErrInternalNoSub
+	}
+
 	if err := stack.Down(ctx); err != nil {
+		// Keep track of what remains, such that deletion can be retried
+		fsist.SetStatus(fs.StatusFailed, "deletion failed")
 		logger.Error(ctx, "stack down",
-			zap.Error(err),
+			zap.Error(multierr.Combine(
+				stack.ExportState(context.WithoutCancel(ctx), fsist),
+				fsist.Save(),
+				err,
+			)),
 		)
-		return nil, err // might be a meaningfull error
+		return err // might be a meaningfull error
 	}
 
 	if err := fsist.Delete(); err != nil {
 		logger.Error(ctx, "removing instance directory",
 			zap.Error(err),
 		)
-		return nil, errs.ErrInternalNoSub
+		return errs.ErrInternalNoSub
 	}
 
 	logger.Info(ctx, "deleted instance successfully")
+	notifyExpired(ctx, fsist, sourceID)
+	common.EmitInstance(ctx, deletedEvent(fsist), fsist, sourceID)
 	common.InstancesUDCounter().Add(ctx, -1,
-		metric.WithAttributeSet(common.InstanceAttrs(req.GetChallengeId(), req.GetSourceId(), false)),
+		metric.WithAttributeSet(common.InstanceAttrs(fschall.ID, sourceID, false)),
 	)
 
 	// Start concurrent routine that will refill the pool if we are now under
@@ -249,14 +343,23 @@ func (man *Manager) DeleteInstance(ctx context.Context, req *DeleteInstanceReque
 	//
 	// XXX data were captured in a concurrent-safe segment of code, but now it might have drifted a bit.
 	// This should be performed in the critical section
-	if len(pooled) < int(fschall.Min) && (fschall.Max == 0 || len(ists)-1 < int(fschall.Max)) {
-		go SpinUp(ctx, req.GetChallengeId())
+	variant := fsist.Variant
+	minVal, maxVal := VariantBounds(ctx, fschall, variant, time.Now())
+	if len(pooledPools[variant]) < int(minVal) && (maxVal == 0 || len(pools[variant])-1 < int(maxVal)) {
+		RequestReconcile(ctx, fschall.ID)
 	}
 
-	// 7. Unlock RW instance
+	// 8. Unlock RW instance
 	//    -> defered after 5 (fault-tolerance)
-	// 8. Unlock R challenge
-	//    -> defered after 2 (fault-tolerance)
 
-	return nil, nil
+	return nil
+}
+
+// deletedEvent returns the type of event of an instance deletion, depending on
+// whether it expired, e.g. deleted by the janitor.
+func deletedEvent(fsist *fs.Instance) string {
+	if expired(fsist) {
+		return events.TypeInstanceJanitored
+	}
+	return events.TypeInstanceDeleted
 }
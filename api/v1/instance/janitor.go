@@ -0,0 +1,193 @@
+package instance
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+// RunJanitor periodically deletes the expired instances of all challenges,
+// until the context is done, with at most parallelism concurrent deletions.
+// The first pass runs right away, such that expired instances don't outlive
+// a restart or leader change by an interval.
+// It does nothing if the interval is not positive.
+// It must only run on the leader (see leader.Run), such that replicas don't
+// delete the same instances concurrently.
+func RunJanitor(ctx context.Context, interval time.Duration, parallelism int) {
+	if interval <= 0 {
+		return
+	}
+	sem := make(chan struct{}, max(parallelism, 1))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		janitorAll(ctx, sem)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// janitorAll deletes the expired instances of all challenges.
+func janitorAll(ctx context.Context, sem chan struct{}) {
+	logger := global.Log()
+
+	logger.Info(ctx, "starting janitoring")
+	challs, err := fs.ListChallenges()
+	if err != nil {
+		logger.Error(ctx, "listing challenges", zap.Error(err))
+		return
+	}
+	for _, challengeID := range challs {
+		if err := Janitor(ctx, challengeID, sem); err != nil {
+			logger.Error(global.WithChallengeID(ctx, challengeID), "janitoring instances",
+				zap.Error(err),
+			)
+		}
+	}
+	logger.Info(ctx, "completed janitoring")
+}
+
+// Janitor deletes the expired instances of a challenge, as the janitor would
+// through the API. The semaphore sem bounds the number of concurrent
+// deletions.
+func Janitor(ctx context.Context, challengeID string, sem chan struct{}) error {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, challengeID)
+
+	ctx, span := global.Tracer.Start(ctx, "janitor", trace.WithAttributes(
+		attribute.String("challenge_id", challengeID),
+	))
+	defer span.End()
+
+	sourceIDs, err := expiredSources(ctx, challengeID)
+	if err != nil {
+		return err
+	}
+
+	// Delete them once the challenge lock is released, as the deletion
+	// acquires it too
+	man := NewManager()
+	wg := &sync.WaitGroup{}
+	for _, sourceID := range sourceIDs {
+		ctx := global.WithSourceID(ctx, sourceID)
+		logger.Info(ctx, "janitoring instance")
+
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+
+			if _, err := man.DeleteInstance(ctx, &DeleteInstanceRequest{
+				ChallengeId: challengeID,
+				SourceId:    sourceID,
+			}); err != nil {
+				if _, ok := err.(*errs.InstanceExist); ok {
+					return // deleted in the meantime
+				}
+				logger.Error(ctx, "janitoring instance", zap.Error(err))
+			}
+		})
+	}
+	wg.Wait()
+	return nil
+}
+
+// expiredSources lists the sources of the expired instances of a challenge.
+// A shared instance is listed for each of its sources.
+func expiredSources(ctx context.Context, challengeID string) ([]string, error) {
+	logger := global.Log()
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := totw.RLock(ctx); err != nil {
+		return nil, err
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		return nil, multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	if err := clock.RLock(ctx); err != nil {
+		return nil, multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		return nil, err
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. Load challenge, it could have been deleted in the meantime
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// 5. Look for expired claimed instances
+	//    (don't lock them, as QueryChallenge does)
+	ists, err := fs.ListInstances(challengeID)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sourceIDs := []string{}
+	for _, identity := range ists {
+		claims, err := fs.LookupClaims(challengeID, identity)
+		if err != nil {
+			if ierr, ok := err.(*errs.InstanceExist); ok && !ierr.Exist {
+				continue // no claim file => in pool
+			}
+			return nil, err
+		}
+		fsist, err := fs.LoadInstance(challengeID, identity)
+		if err != nil {
+			return nil, err
+		}
+		if !expired(fsist) {
+			continue
+		}
+		// Paused time is credited back on resume, so can't expire meanwhile
+		if fschall.ExcludePausedTime && fsist.PausedAt != nil {
+			continue
+		}
+		sourceIDs = append(sourceIDs, claims...)
+	}
+	return sourceIDs, nil
+}
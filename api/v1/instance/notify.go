@@ -0,0 +1,239 @@
+package instance
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+	"github.com/ctfer-io/chall-manager/pkg/notify"
+)
+
+var (
+	notifierInst *notify.Notifier
+	notifierOnce sync.Once
+)
+
+// notifier returns the notifier of instances events, or nil if no webhook is
+// configured.
+func notifier() *notify.Notifier {
+	notifierOnce.Do(func() {
+		if len(global.Conf.Notify.Webhooks) == 0 {
+			return
+		}
+		notifierInst = &notify.Notifier{
+			Outbox:      notify.NewOutbox(),
+			Webhooks:    global.Conf.Notify.Webhooks,
+			Secret:      global.Conf.Notify.Secret,
+			MaxAttempts: global.Conf.Notify.MaxAttempts,
+			Client: &http.Client{
+				Transport: otelhttp.NewTransport(http.DefaultTransport),
+			},
+		}
+	})
+	return notifierInst
+}
+
+// notifyExpired notifies the sources of an instance deleted once expired, e.g.
+// by the janitor. It is delivered later by the leader.
+// Notification is best effort: failing to persist it does not prevent the
+// deletion.
+func notifyExpired(ctx context.Context, fsist *fs.Instance, sourceID string) {
+	n := notifier()
+	if n == nil || !expired(fsist) {
+		return
+	}
+	if err := n.Notify(ctx, notify.TypeExpired, fsist.ChallengeID, sourceID, fsist.Until); err != nil {
+		global.Log().Warn(ctx, "persisting expiration notification",
+			zap.Error(err),
+		)
+	}
+}
+
+// RunNotifier periodically warns the sources whose instances are about to
+// expire, then delivers the pending notifications, until the context is done.
+// It must only run on the leader (see leader.Run).
+func RunNotifier(ctx context.Context, interval time.Duration) {
+	n := notifier()
+	if n == nil {
+		return
+	}
+	logger := global.Log()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if global.Conf.Notify.Before > 0 {
+			challs, err := fs.ListChallenges()
+			if err != nil {
+				logger.Error(ctx, "listing challenges", zap.Error(err))
+			}
+			for _, challengeID := range challs {
+				if err := WarnExpiring(ctx, challengeID); err != nil {
+					logger.Error(global.WithChallengeID(ctx, challengeID), "warning expiring instances",
+						zap.Error(err),
+					)
+				}
+			}
+		}
+
+		if err := n.Flush(ctx); err != nil {
+			logger.Error(ctx, "delivering notifications", zap.Error(err))
+		}
+	}
+}
+
+// WarnExpiring notifies the sources of the instances of a challenge that
+// expire within the configured delay. Each source is warned once per until
+// date, i.e. warned again once renewed.
+func WarnExpiring(ctx context.Context, challengeID string) error {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, challengeID)
+
+	ctx, span := global.Tracer.Start(ctx, "warn-expiring", trace.WithAttributes(
+		attribute.String("challenge_id", challengeID),
+	))
+	defer span.End()
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		return err
+	}
+	if err := totw.RLock(ctx); err != nil {
+		return err
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	if err := clock.RLock(ctx); err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. Load challenge, it could have been deleted in the meantime
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return nil
+		}
+		return err
+	}
+	if fschall.Timeout == nil && fschall.Until == nil && fschall.MaxLifetime == nil {
+		return nil // instances never expire
+	}
+
+	// 5. Warn the sources of claimed instances
+	ists, err := fs.ListInstances(challengeID)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, identity := range ists {
+		claims, err := fs.LookupClaims(challengeID, identity)
+		if err != nil {
+			if ierr, ok := err.(*errs.InstanceExist); ok && !ierr.Exist {
+				continue // no claim file => in pool
+			}
+			return err
+		}
+		if err := warnInstance(ctx, fschall, identity, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warnInstance notifies the sources of an instance if it expires soon.
+// It must be called with the challenge R lock held.
+func warnInstance(ctx context.Context, fschall *fs.Challenge, identity string, sourceIDs []string) error {
+	logger := global.Log()
+	ctx = global.WithIdentity(ctx, identity)
+
+	ilock, err := common.LockInstance(ctx, fschall.ID, identity)
+	if err != nil {
+		return err
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		return err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	fsist, err := fs.LoadInstance(fschall.ID, identity)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil // deleted in the meantime
+		}
+		return err
+	}
+	now := time.Now()
+	if fsist.Until == nil || now.After(*fsist.Until) || fsist.Until.Sub(now) > global.Conf.Notify.Before {
+		return nil
+	}
+	if fsist.WarnedUntil != nil && fsist.WarnedUntil.Equal(*fsist.Until) {
+		return nil // already warned
+	}
+	// Paused time is credited back on resume, so can't expire meanwhile
+	if fschall.ExcludePausedTime && fsist.PausedAt != nil {
+		return nil
+	}
+
+	for _, sourceID := range sourceIDs {
+		if err := notifier().Notify(ctx, notify.TypeExpiring, fschall.ID, sourceID, fsist.Until); err != nil {
+			return err
+		}
+	}
+	logger.Info(ctx, "warned expiring instance",
+		zap.Strings("source_ids", sourceIDs),
+	)
+	fsist.WarnedUntil = fsist.Until
+	return fsist.Save()
+}
+
+// expired returns whether an instance is past its until date.
+func expired(fsist *fs.Instance) bool {
+	return fsist.Until != nil && time.Now().After(*fsist.Until)
+}
@@ -0,0 +1,277 @@
+package instance
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) PauseInstance(ctx context.Context, req *PauseInstanceRequest) (*Instance, error) {
+	return pause(ctx, req.GetChallengeId(), req.GetSourceId(), true)
+}
+
+func (man *Manager) ResumeInstance(ctx context.Context, req *ResumeInstanceRequest) (*Instance, error) {
+	return pause(ctx, req.GetChallengeId(), req.GetSourceId(), false)
+}
+
+// pause pauses or resumes the instance of a source.
+func pause(ctx context.Context, challengeID, sourceID string, paused bool) (*Instance, error) {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, challengeID)
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+		}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+		}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge does not exist, return error
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		// If challenge not found
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return nil, err
+		}
+		// Else deal with it as an internal server error
+		logger.Error(ctx, "loading challenge",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	id, err := fs.FindInstance(challengeID, sourceID)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil, err
+		}
+
+		logger.Error(ctx, "finding instance", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+
+	// 5. Lock RW instance
+	ctx = global.WithSourceID(ctx, sourceID)
+	ctx = global.WithIdentity(ctx, id)
+	ilock, err := common.LockInstance(ctx, challengeID, id)
+	if err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "build challenge lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "challenge instance RW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// 6. If instance does not exist, return error (+ Unlock RW instance, Unlock R challenge)
+	fsist, err := fs.LoadInstance(challengeID, id)
+	if err != nil {
+		logger.Error(ctx, "loading challenge instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	claims, err := fs.LookupClaims(challengeID, id)
+	if err != nil {
+		logger.Error(ctx, "looking up for claims",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if len(claims) > 1 {
+		// This makes sure a source does not disrupt the others
+		return nil, &errs.InstanceShared{
+			ChallengeID: challengeID,
+			SourceID:    sourceID,
+			Sources:     len(claims),
+		}
+	}
+
+	// 7. Pause or resume the instance if not already
+	if (fsist.PausedAt != nil) != paused {
+		if current := Status(fsist); current != InstanceStatus_ready && current != InstanceStatus_paused {
+			// This makes sure the stack is not messed with while broken
+			st, err := status.New(codes.FailedPrecondition, "Instance is not ready.").WithDetails(
+				&errdetails.ErrorInfo{
+					Reason: errs.ReasonInstanceNotReady,
+					Domain: errs.Domain,
+					Metadata: map[string]string{
+						"challenge_id": challengeID,
+						"source_id":    sourceID,
+					},
+				},
+				&errdetails.PreconditionFailure{
+					Violations: []*errdetails.PreconditionFailure_Violation{
+						{
+							Type:        "STATUS",
+							Subject:     errs.Domain + "/Instance",
+							Description: "Instance is " + current.String() + " so can not be paused nor resumed.",
+						},
+					},
+				},
+			)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to build error: %v", err)
+			}
+			return nil, st.Err()
+		}
+
+		if paused {
+			logger.Info(ctx, "pausing instance")
+		} else {
+			logger.Info(ctx, "resuming instance")
+		}
+		if err := iac.Pause(context.WithoutCancel(ctx), fschall, fsist, paused); err != nil {
+			logger.Error(ctx, "pausing instance",
+				zap.Bool("paused", paused),
+				zap.Error(err),
+			)
+			msg := "resume failed"
+			if paused {
+				msg = "pause failed"
+			}
+			fsist.SetStatus(fs.StatusFailed, msg)
+			if err := fsist.Save(); err != nil {
+				logger.Error(ctx, "exporting instance information to filesystem",
+					zap.Error(err),
+				)
+			}
+			return nil, errs.ErrInternalNoSub
+		}
+
+		now := time.Now()
+		if paused {
+			fsist.PausedAt = &now
+		} else {
+			// Postpone the expiration by the time spent paused
+			if fschall.ExcludePausedTime && fsist.Until != nil {
+				until := fsist.Until.Add(now.Sub(*fsist.PausedAt))
+				if deadline := fschall.Deadline(fsist.Since); deadline != nil && until.After(*deadline) {
+					until = *deadline
+				}
+				fsist.Until = &until
+			}
+			fsist.PausedAt = nil
+		}
+		fsist.Settle()
+
+		if err := fsist.Save(); err != nil {
+			logger.Error(ctx, "exporting instance information to filesystem",
+				zap.Error(err),
+			)
+			return nil, errs.ErrInternalNoSub
+		}
+		common.EmitInstance(ctx, events.TypeInstanceUpdated, fsist, sourceID)
+	}
+
+	// 8. Unlock RW instance
+	//    -> defered after 5 (fault-tolerance)
+	// 9. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	var until *timestamppb.Timestamp
+	if fsist.Until != nil {
+		until = timestamppb.New(*fsist.Until)
+	}
+	return &Instance{
+		ChallengeId:    challengeID,
+		SourceId:       sourceID,
+		Since:          timestamppb.New(fsist.Since),
+		LastRenew:      timestamppb.New(fsist.LastRenew),
+		Until:          until,
+		ConnectionInfo: fsist.ConnectionInfo,
+		Flag: func() *string { // kept for retrocompatibility enough time for public migration
+			if len(fsist.Flags) == 1 {
+				return &fsist.Flags[0]
+			}
+			return nil
+		}(),
+		Flags:         fsist.Flags,
+		Status:        Status(fsist),
+		StatusMessage: fsist.StatusMessage,
+		Renewals:      fsist.Renewals,
+	}, nil
+}
@@ -0,0 +1,635 @@
+package instance
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+	"github.com/ctfer-io/chall-manager/pkg/probe"
+)
+
+// poolKey identifies a pool: the default one of a challenge (empty variant)
+// or one of its variants.
+type poolKey struct {
+	challengeID string
+	variant     string
+}
+
+// inflight counts the pool spin-ups in progress per pool, such that
+// reconciliations don't over-provision while instances are being deployed.
+var inflight = struct {
+	sync.Mutex
+	m map[poolKey]int64
+}{
+	m: map[poolKey]int64{},
+}
+
+func addInflight(challengeID, variant string, n int64) {
+	inflight.Lock()
+	defer inflight.Unlock()
+
+	k := poolKey{challengeID: challengeID, variant: variant}
+	inflight.m[k] += n
+	if inflight.m[k] <= 0 {
+		delete(inflight.m, k)
+	}
+}
+
+func inflightOf(challengeID, variant string) int64 {
+	inflight.Lock()
+	defer inflight.Unlock()
+
+	return inflight.m[poolKey{challengeID: challengeID, variant: variant}]
+}
+
+// Inflight returns the number of pool spin-ups in progress for a challenge,
+// all variants included.
+// They are counted from the pending operations, as they are shared by all
+// replicas while only the leader spins up.
+func Inflight(ctx context.Context, challengeID string) (n int64, err error) {
+	ops, err := pending().List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, op := range ops {
+		if op.ChallengeID == challengeID {
+			n++
+		}
+	}
+	return
+}
+
+// PoolStats returns the recent spin-up failures and the average spin-up time
+// of a challenge pool.
+func PoolStats(ctx context.Context, challengeID string) ([]pool.Failure, time.Duration, error) {
+	st, err := readPoolState(ctx, challengeID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return st.Stats.Failures(), st.Stats.Average(), nil
+}
+
+// Breaker returns the pool circuit breaker state of a challenge, the number
+// of consecutive spin-up failures, and when spin-ups can be retried.
+// The breaker stops the pool spin-ups when they keep failing. It is shared by
+// all replicas, as any of them can reset it while only the leader spins up.
+func Breaker(ctx context.Context, challengeID string) (pool.BreakerState, int, time.Time, error) {
+	st, err := readPoolState(ctx, challengeID)
+	if err != nil {
+		return 0, 0, time.Time{}, err
+	}
+	state, failures, retryAt := st.Breaker.State()
+	return state, failures, retryAt, nil
+}
+
+// ResetBreaker closes the pool circuit breaker of a challenge, such that
+// spin-ups resume right away.
+func ResetBreaker(ctx context.Context, challengeID string) error {
+	return updatePoolState(ctx, challengeID, func(st *poolState) {
+		st.Breaker.Reset()
+		recordBreaker(ctx, challengeID, st.Breaker)
+	})
+}
+
+func recordBreaker(ctx context.Context, challengeID string, b *pool.Breaker) {
+	state, _, _ := b.State()
+	common.PoolBreakerGauge().Record(ctx, int64(state),
+		metric.WithAttributes(attribute.String("challenge", challengeID)),
+	)
+}
+
+// ForgetPool drops what is kept about a challenge pool, e.g. once the
+// challenge is deleted.
+func ForgetPool(ctx context.Context, challengeID string) error {
+	return dropPoolState(ctx, challengeID)
+}
+
+// claimDemand records a claim in the demand of a challenge pool, for
+// autoscaling. Claims are served by any replica, so the demand is shared.
+func claimDemand(ctx context.Context, challengeID string, t time.Time) {
+	if err := updatePoolState(ctx, challengeID, func(st *poolState) {
+		st.Demand.Claim(t)
+	}); err != nil {
+		global.Log().Error(ctx, "recording pool demand", zap.Error(err))
+	}
+}
+
+// spinUpDemand records the duration it took to deploy an instance of a
+// challenge, for autoscaling.
+func spinUpDemand(ctx context.Context, challengeID string, dur time.Duration) {
+	if err := updatePoolState(ctx, challengeID, func(st *poolState) {
+		st.Demand.SpinUp(dur)
+	}); err != nil {
+		global.Log().Error(ctx, "recording pool spin-up latency", zap.Error(err))
+	}
+}
+
+// PoolBounds returns the pool boundaries of a challenge at the given time.
+// If the challenge pool is autoscaled, the minimum is raised to the target
+// that covers the current demand.
+func PoolBounds(ctx context.Context, fschall *fs.Challenge, t time.Time) (minVal, maxVal int64) {
+	minVal, maxVal = fschall.PoolBounds(t)
+	if !fschall.Autoscale {
+		return
+	}
+
+	st, err := readPoolState(ctx, fschall.ID)
+	if err != nil {
+		global.Log().Error(ctx, "reading pool demand", zap.Error(err))
+		return
+	}
+	minVal = st.Demand.Target(t, minVal, maxVal)
+	common.PoolTargetGauge().Record(ctx, minVal,
+		metric.WithAttributes(attribute.String("challenge", fschall.ID)),
+	)
+	return
+}
+
+// VariantBounds returns the pool boundaries of a challenge pool: those of
+// the named variant, or the default ones (see PoolBounds) if empty.
+func VariantBounds(ctx context.Context, fschall *fs.Challenge, variant string, t time.Time) (minVal, maxVal int64) {
+	if variant == "" {
+		return PoolBounds(ctx, fschall, t)
+	}
+	v, _ := pool.Find(fschall.Variants, variant)
+	return v.Min, v.Max
+}
+
+// SplitPools groups instances of a challenge per the pool variant they have
+// been deployed for, the default pool being the empty variant.
+// Instances of a variant that has been removed, or whose additional values
+// changed since, are outdated thus grouped apart.
+func SplitPools(fschall *fs.Challenge, identities []string) (pools map[string][]string, outdated []string, err error) {
+	pools = map[string][]string{}
+	for _, identity := range identities {
+		fsist, err := fs.LoadInstance(fschall.ID, identity)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isOutdated(fschall, fsist) {
+			outdated = append(outdated, identity)
+			continue
+		}
+		pools[fsist.Variant] = append(pools[fsist.Variant], identity)
+	}
+	return
+}
+
+// isOutdated returns whether an instance has been deployed for a variant that
+// no longer exists or no longer has the same additional values.
+func isOutdated(fschall *fs.Challenge, fsist *fs.Instance) bool {
+	if fsist.Variant == "" {
+		return false
+	}
+	v, ok := pool.Find(fschall.Variants, fsist.Variant)
+	return !ok || !maps.Equal(v.Additional, fsist.Additional)
+}
+
+// RunScheduler periodically reconciles the pool of every challenge until the
+// context is canceled. It is the one applying pooler schedules and autoscaling
+// through time.
+func RunScheduler(ctx context.Context, interval time.Duration) {
+	logger := global.Log()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		challs, err := fs.ListChallenges()
+		if err != nil {
+			logger.Error(ctx, "listing challenges", zap.Error(err))
+			continue
+		}
+		for _, challengeID := range challs {
+			if err := Reconcile(ctx, challengeID); err != nil {
+				logger.Error(global.WithChallengeID(ctx, challengeID), "reconciling pool",
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// Reconcile resizes the pool of a challenge to the boundaries that currently
+// apply, spinning up or deleting pooled instances.
+func Reconcile(ctx context.Context, challengeID string) error {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, challengeID)
+
+	ctx, span := global.Tracer.Start(ctx, "pool-reconcile", trace.WithAttributes(
+		attribute.String("challenge_id", challengeID),
+	))
+	defer span.End()
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		return err
+	}
+	if err := totw.RLock(ctx); err != nil {
+		return err
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock RW challenge, such that no claim happens while resizing
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	if err := clock.RWLock(ctx); err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge RW unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. Load challenge, it could have been deleted in the meantime
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return nil
+		}
+		return err
+	}
+	if fschall.Until != nil && time.Now().After(*fschall.Until) {
+		return nil // expired challenges are left to the janitor
+	}
+
+	// 5. Compute deltas, per pool
+	ists, err := fs.ListInstances(challengeID)
+	if err != nil {
+		return err
+	}
+	claimedIsts := []string{}
+	pooled := []string{}
+	for _, ist := range ists {
+		_, err := fs.LookupClaim(challengeID, ist)
+		if err, ok := err.(*errs.InstanceExist); ok && !err.Exist {
+			pooled = append(pooled, ist)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		claimedIsts = append(claimedIsts, ist)
+	}
+	claimed, _, err := SplitPools(fschall, claimedIsts)
+	if err != nil {
+		return err
+	}
+
+	// Replace unhealthy pooled instances, they should not be claimed
+	healthy := make([]bool, len(pooled))
+	outdated := make([]bool, len(pooled))
+	deleting := make([]bool, len(pooled))
+	loaded := make([]pooledInstance, len(pooled))
+	wg := &sync.WaitGroup{}
+	for i, identity := range pooled {
+		wg.Go(func() {
+			fsist, err := fs.LoadInstance(challengeID, identity)
+			if err != nil {
+				return
+			}
+			loaded[i] = pooledInstance{
+				identity: identity,
+				since:    fsist.Since,
+				variant:  fsist.Variant,
+			}
+			deleting[i] = fsist.Status == fs.StatusDeleting
+			outdated[i] = isOutdated(fschall, fsist)
+			// Starting instances are not claimable yet, though not broken
+			healthy[i] = outdated[i] || fsist.Status == fs.StatusStarting || probeHealthy(ctx, fsist)
+		})
+	}
+	wg.Wait()
+
+	var merr error
+	stocks := map[string][]pooledInstance{}
+	for i, identity := range pooled {
+		if deleting[i] {
+			// Already retired, e.g. its destruction failed
+			destroyPooled(ctx, challengeID, identity)
+			continue
+		}
+		if !healthy[i] {
+			logger.Warn(global.WithIdentity(ctx, identity), "replacing unhealthy pooled instance")
+			merr = multierr.Append(merr, retirePooled(ctx, challengeID, identity))
+			continue
+		}
+		pi := loaded[i]
+		if outdated[i] {
+			logger.Info(global.WithIdentity(ctx, identity), "deleting outdated variant pooled instance",
+				zap.String("variant", pi.variant),
+			)
+			merr = multierr.Append(merr, retirePooled(ctx, challengeID, identity))
+			continue
+		}
+		stocks[pi.variant] = append(stocks[pi.variant], pi)
+	}
+
+	now := time.Now()
+	variants := []string{""}
+	for _, v := range fschall.Variants {
+		variants = append(variants, v.Name)
+	}
+	for _, variant := range variants {
+		merr = multierr.Append(merr, reconcilePool(ctx, fschall, variant, now,
+			int64(len(claimed[variant])), stocks[variant],
+		))
+	}
+	return merr
+}
+
+// reconcilePool resizes one pool of a challenge, given its claimed instances
+// count and its healthy pooled instances.
+// It must be called with the challenge RW lock held.
+func reconcilePool(
+	ctx context.Context,
+	fschall *fs.Challenge,
+	variant string,
+	now time.Time,
+	claimed int64,
+	stock []pooledInstance,
+) error {
+	logger := global.Log()
+
+	// Sort from the oldest to the youngest, such that shrinking the pool
+	// drops the oldest instances first.
+	slices.SortFunc(stock, func(a, b pooledInstance) int {
+		return a.since.Compare(b.since)
+	})
+
+	minVal, maxVal := VariantBounds(ctx, fschall, variant, now)
+	spinning := inflightOf(fschall.ID, variant)
+	delta := pool.NewDelta(minVal, maxVal, claimed, int64(len(stock))+spinning)
+	if delta.Create == 0 && delta.Delete == 0 {
+		// The pool is at its desired size, recycle the oldest instance if
+		// stale. Only one at a time, and only once previous replacements
+		// are done, to renew the pool gradually.
+		if spinning != 0 || len(stock) == 0 || !pool.Stale(stock[0].since, now, fschall.PoolMaxAge) {
+			return nil
+		}
+		logger.Info(global.WithIdentity(ctx, stock[0].identity), "recycling stale pooled instance",
+			zap.String("variant", variant),
+			zap.Duration("age", now.Sub(stock[0].since)),
+		)
+		delta = pool.Recycle(maxVal, claimed, int64(len(stock)))
+	}
+
+	logger.Info(ctx, "reconciling pool",
+		zap.String("variant", variant),
+		zap.Int64("min", minVal),
+		zap.Int64("max", maxVal),
+		zap.Int64("claimed", claimed),
+		zap.Int("pooled", len(stock)),
+		zap.Int64("inflight", spinning),
+		zap.Int64("create", delta.Create),
+		zap.Int64("delete", delta.Delete),
+	)
+
+	// 6. Apply delta
+	for range delta.Create {
+		go SpinUp(ctx, fschall.ID, variant)
+	}
+
+	// In-flight spin-ups can't be canceled, so only delete what is
+	// already pooled.
+	var merr error
+	for _, pi := range stock[:min(delta.Delete, int64(len(stock)))] {
+		merr = multierr.Append(merr, retirePooled(ctx, fschall.ID, pi.identity))
+	}
+	return merr
+}
+
+// firstHealthy returns the first of pooled instances that passes its
+// healthcheck, if any, along the number of unhealthy ones.
+// They are probed concurrently, such that a claim holds the challenge lock for
+// at most one probe timeout (see probe.Timeout), whatever the pool size.
+func firstHealthy(ctx context.Context, challengeID string, identities []string) (candidate string, broken int) {
+	healthy := make([]bool, len(identities))
+	wg := &sync.WaitGroup{}
+	for i, identity := range identities {
+		wg.Go(func() {
+			healthy[i] = isHealthy(ctx, challengeID, identity)
+		})
+	}
+	wg.Wait()
+
+	for i, identity := range identities {
+		if !healthy[i] {
+			broken++
+			continue
+		}
+		if candidate == "" {
+			candidate = identity
+		}
+	}
+	return
+}
+
+// isHealthy returns whether a pooled instance passes its healthcheck, if any.
+// An instance that cannot be loaded is considered unhealthy.
+func isHealthy(ctx context.Context, challengeID, identity string) bool {
+	fsist, err := fs.LoadInstance(challengeID, identity)
+	if err != nil {
+		return false
+	}
+	return probeHealthy(ctx, fsist)
+}
+
+// probeHealthy returns whether a loaded pooled instance is ready and passes
+// its healthcheck, if any.
+func probeHealthy(ctx context.Context, fsist *fs.Instance) bool {
+	if fsist.Status != "" && fsist.Status != fs.StatusReady {
+		return false
+	}
+	if fsist.Healthcheck == "" {
+		return true
+	}
+	if err := probe.Check(ctx, fsist.Healthcheck); err != nil {
+		global.Log().Debug(global.WithIdentity(ctx, fsist.Identity), "pooled instance healthcheck failed",
+			zap.Error(err),
+		)
+		return false
+	}
+	return true
+}
+
+// retirePooled removes a pooled instance from the pool, such that it can't
+// be claimed anymore, then destroys it in background.
+// It must be called with the challenge RW lock held, such that no one could
+// claim it in the meantime.
+func retirePooled(ctx context.Context, challengeID, identity string) error {
+	fsist, err := fs.LoadInstance(challengeID, identity)
+	if err != nil {
+		return err
+	}
+	fsist.SetStatus(fs.StatusDeleting, "")
+	if err := fsist.Save(); err != nil {
+		return err
+	}
+	destroyPooled(ctx, challengeID, identity)
+	return nil
+}
+
+// destroying tracks the retired pooled instances being destroyed by this
+// replica.
+var destroying sync.Map // challenge ID + identity -> struct{}
+
+// destroyPooled destroys a retired pooled instance in background, unless it
+// is already.
+// As spin-ups, it relocks the TOTW/challenge locks so does not hold the
+// challenge back while destroying.
+func destroyPooled(ctx context.Context, challengeID, identity string) {
+	k := challengeID + "/" + identity
+	if _, loaded := destroying.LoadOrStore(k, struct{}{}); loaded {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	ctx = global.WithIdentity(ctx, identity)
+	go func() {
+		defer destroying.Delete(k)
+
+		if err := deletePooled(ctx, challengeID, identity); err != nil {
+			global.Log().Error(ctx, "deleting pooled instance",
+				zap.Error(err),
+			)
+		}
+	}()
+}
+
+// deletePooled destroys a retired pooled instance.
+// If it fails, the instance remains retired such that the next reconciliation
+// retries.
+func deletePooled(ctx context.Context, challengeID, identity string) error {
+	logger := global.Log()
+
+	// 1. Lock R TOTW
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		return err
+	}
+	if err := totw.RLock(ctx); err != nil {
+		return err
+	}
+
+	// 2. Lock R challenge, retired instances can't be claimed
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	if err := clock.RLock(ctx); err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+
+	// 4. Load challenge and instance, they could have been deleted in the
+	//    meantime
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return nil
+		}
+		return err
+	}
+	fsist, err := fs.LoadInstance(challengeID, identity)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil
+		}
+		return err
+	}
+	if fsist.Status != fs.StatusDeleting {
+		return nil
+	}
+
+	// 5. Destroy it
+	logger.Debug(ctx, "deleting pooled instance")
+
+	stack, err := iac.LoadStack(ctx, fschall.Scenario, identity)
+	if err != nil {
+		return err
+	}
+	if fsist.State != nil {
+		if err := stack.Import(ctx, fsist); err != nil {
+			return err
+		}
+	}
+	if err := stack.Down(ctx); err != nil {
+		// Keep track of what remains, such that deletion can be retried
+		return multierr.Combine(
+			stack.ExportState(ctx, fsist),
+			fsist.Save(),
+			err,
+		)
+	}
+	if err := fsist.Delete(); err != nil {
+		return err
+	}
+
+	common.InstancesUDCounter().Add(ctx, -1,
+		metric.WithAttributeSet(common.InstanceAttrs(challengeID, "", true)),
+	)
+	logger.Debug(ctx, "deleted pooled instance successfully")
+	return nil
+}
+
+// pooledInstance is a pooled instance along its deployment date and the
+// variant it has been deployed for.
+type pooledInstance struct {
+	identity string
+	since    time.Time
+	variant  string
+}
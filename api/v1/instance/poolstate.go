@@ -0,0 +1,130 @@
+package instance
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+	"github.com/ctfer-io/chall-manager/pkg/store"
+)
+
+// poolStatePrefix is the etcd prefix under which the state of the pools is
+// shared by all replicas.
+const poolStatePrefix = "/chall-manager/pool/state/"
+
+// poolState is the state of a challenge pool, shared by all replicas: any of
+// them serves claims, while only the leader spins up instances.
+type poolState struct {
+	ChallengeID string        `json:"challenge_id"`
+	Demand      *pool.Demand  `json:"demand"`
+	Breaker     *pool.Breaker `json:"breaker"`
+	Stats       *pool.Stats   `json:"stats"`
+}
+
+var (
+	poolStates     store.Store[poolState]
+	poolStatesOnce sync.Once
+)
+
+// poolStore returns the store of the pools state: in etcd when configured,
+// on the filesystem otherwise.
+func poolStore() store.Store[poolState] {
+	poolStatesOnce.Do(func() {
+		poolStates = store.New(filepath.Join(global.Conf.Directory, "pool"), poolStatePrefix, func(st *poolState) string {
+			return fs.Hash(st.ChallengeID)
+		})
+	})
+	return poolStates
+}
+
+// loadPoolState returns the state of a challenge pool, empty if none yet.
+// It must be called with the pool lock held.
+func loadPoolState(ctx context.Context, challengeID string) (*poolState, error) {
+	st, err := poolStore().Get(ctx, fs.Hash(challengeID))
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		st = &poolState{
+			ChallengeID: challengeID,
+		}
+	}
+	if st.Demand == nil {
+		st.Demand = &pool.Demand{}
+	}
+	if st.Breaker == nil {
+		st.Breaker = &pool.Breaker{}
+	}
+	if st.Stats == nil {
+		st.Stats = &pool.Stats{}
+	}
+	return st, nil
+}
+
+// readPoolState returns the state of a challenge pool.
+func readPoolState(ctx context.Context, challengeID string) (*poolState, error) {
+	plock, err := common.LockPool(ctx, challengeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := plock.RLock(ctx); err != nil {
+		return nil, err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			global.Log().Error(ctx, "pool R unlock", zap.Error(err))
+		}
+	}(plock)
+
+	return loadPoolState(ctx, challengeID)
+}
+
+// updatePoolState applies a change to the state of a challenge pool, such
+// that concurrent changes from other replicas are not lost.
+func updatePoolState(ctx context.Context, challengeID string, update func(st *poolState)) error {
+	plock, err := common.LockPool(ctx, challengeID)
+	if err != nil {
+		return err
+	}
+	if err := plock.RWLock(ctx); err != nil {
+		return err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			global.Log().Error(ctx, "pool RW unlock", zap.Error(err))
+		}
+	}(plock)
+
+	st, err := loadPoolState(ctx, challengeID)
+	if err != nil {
+		return err
+	}
+	update(st)
+	return poolStore().Push(ctx, st)
+}
+
+// dropPoolState removes the state of a challenge pool, e.g. once the
+// challenge is deleted.
+func dropPoolState(ctx context.Context, challengeID string) error {
+	plock, err := common.LockPool(ctx, challengeID)
+	if err != nil {
+		return err
+	}
+	if err := plock.RWLock(ctx); err != nil {
+		return err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			global.Log().Error(ctx, "pool RW unlock", zap.Error(err))
+		}
+	}(plock)
+
+	return poolStore().Done(ctx, fs.Hash(challengeID))
+}
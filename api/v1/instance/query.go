@@ -2,6 +2,7 @@ package instance
 
 import (
 	"context"
+	"slices"
 	sync "sync"
 
 	"github.com/ctfer-io/chall-manager/api/v1/common"
@@ -108,6 +109,9 @@ func (man *Manager) QueryInstance(req *QueryInstanceRequest, server InstanceMana
 				cerr <- err
 				return
 			}
+			if len(req.GetStatuses()) != 0 && !slices.Contains(req.GetStatuses(), Status(fsist)) {
+				return
+			}
 
 			var until *timestamppb.Timestamp
 			if fsist.Until != nil {
@@ -127,8 +131,11 @@ func (man *Manager) QueryInstance(req *QueryInstanceRequest, server InstanceMana
 					}
 					return nil
 				}(),
-				Flags:      fsist.Flags,
-				Additional: fsist.Additional,
+				Flags:         fsist.Flags,
+				Additional:    fsist.Additional,
+				Status:        Status(fsist),
+				StatusMessage: fsist.StatusMessage,
+				Renewals:      fsist.Renewals,
 			}); err != nil {
 				cerr <- err
 				return
@@ -0,0 +1,174 @@
+package instance
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/identity"
+	"github.com/ctfer-io/chall-manager/pkg/queue"
+)
+
+var (
+	pendingQueue queue.Queue
+	pendingOnce  sync.Once
+
+	// ownPending holds the IDs of the pending operations pushed by this
+	// replica, that are accounted as in-flight already.
+	ownPending sync.Map
+)
+
+// pendingPollInterval is the interval at which the pending operations of
+// the previous leader are checked for completion.
+const pendingPollInterval = 5 * time.Second
+
+// pending returns the queue of pending pool operations.
+func pending() queue.Queue {
+	pendingOnce.Do(func() {
+		pendingQueue = queue.New()
+	})
+	return pendingQueue
+}
+
+// pushPending persists a pool spin-up until it completes. It returns the
+// function to call on completion.
+// Persistence is best effort: failing to persist does not prevent the spin-up,
+// as the scheduler would reconcile the pool anyway.
+func pushPending(ctx context.Context, challengeID, variant string) (done func()) {
+	logger := global.Log()
+
+	op := &queue.Op{
+		ID:          identity.New(),
+		ChallengeID: challengeID,
+		Variant:     variant,
+		At:          time.Now(),
+	}
+	if err := pending().Push(ctx, op); err != nil {
+		logger.Warn(ctx, "persisting pending pool operation",
+			zap.Error(err),
+		)
+		return func() {}
+	}
+	ownPending.Store(op.ID, struct{}{})
+	return func() {
+		ownPending.Delete(op.ID)
+		if err := pending().Done(ctx, op.ID); err != nil {
+			logger.Warn(ctx, "removing pending pool operation",
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// ResumePending resumes the pool operations that were pending when the
+// previous leader stopped. Rather than replaying them blindly, the pools of
+// the challenges they targeted are reconciled, such that they are refilled to
+// their boundaries without overshooting.
+// With etcd, the previous leader may still be running them, so they are
+// accounted as in-flight until they complete or time out, and only then are
+// their pools reconciled.
+// It should be called once elected.
+func ResumePending(ctx context.Context) {
+	logger := global.Log()
+	elected := time.Now()
+
+	all, err := pending().List(ctx)
+	if err != nil {
+		logger.Error(ctx, "listing pending pool operations", zap.Error(err))
+		return
+	}
+	ops := slices.DeleteFunc(all, func(op *queue.Op) bool {
+		_, own := ownPending.Load(op.ID)
+		return own || !op.At.Before(elected)
+	})
+	if len(ops) == 0 {
+		return
+	}
+	logger.Info(ctx, "resuming pending pool operations",
+		zap.Int("count", len(ops)),
+	)
+
+	// Without etcd there is a single replica, so the previous one is gone
+	if global.Conf.Etcd.Endpoint == "" {
+		resumePending(ctx, ops...)
+		return
+	}
+
+	for _, op := range ops {
+		addInflight(op.ChallengeID, op.Variant, 1)
+	}
+	go awaitPending(ctx, ops)
+}
+
+// awaitPending waits for the pending operations of the previous leader to
+// complete or time out, then resumes them.
+// They are accounted as in-flight until then.
+func awaitPending(ctx context.Context, ops []*queue.Op) {
+	logger := global.Log()
+
+	ticker := time.NewTicker(pendingPollInterval)
+	defer ticker.Stop()
+
+	for len(ops) != 0 {
+		select {
+		case <-ctx.Done():
+			// Leadership lost, the next leader takes over
+			for _, op := range ops {
+				addInflight(op.ChallengeID, op.Variant, -1)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		all, err := pending().List(ctx)
+		if err != nil {
+			logger.Error(ctx, "listing pending pool operations", zap.Error(err))
+			continue
+		}
+		ids := map[string]struct{}{}
+		for _, op := range all {
+			ids[op.ID] = struct{}{}
+		}
+
+		now := time.Now()
+		over := []*queue.Op{}
+		ops = slices.DeleteFunc(ops, func(op *queue.Op) bool {
+			_, ok := ids[op.ID]
+			if ok && now.Before(op.At.Add(global.Conf.Pool.PendingTimeout)) {
+				return false // still running
+			}
+			if ok {
+				logger.Warn(global.WithChallengeID(ctx, op.ChallengeID), "pending pool operation timed out")
+			}
+			addInflight(op.ChallengeID, op.Variant, -1)
+			over = append(over, op)
+			return true
+		})
+		resumePending(ctx, over...)
+	}
+}
+
+// resumePending removes pending operations then reconciles the pools they
+// targeted.
+func resumePending(ctx context.Context, ops ...*queue.Op) {
+	logger := global.Log()
+
+	challs := map[string]struct{}{}
+	for _, op := range ops {
+		challs[op.ChallengeID] = struct{}{}
+		if err := pending().Done(ctx, op.ID); err != nil {
+			logger.Error(ctx, "removing pending pool operation", zap.Error(err))
+		}
+	}
+	for challengeID := range challs {
+		if err := Reconcile(ctx, challengeID); err != nil {
+			logger.Error(global.WithChallengeID(ctx, challengeID), "reconciling pool",
+				zap.Error(err),
+			)
+		}
+	}
+}
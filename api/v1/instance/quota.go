@@ -0,0 +1,61 @@
+package instance
+
+import (
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Quota returns the maximum number of instances a source can hold at once to
+// create an instance of the challenge, or 0 if unlimited.
+// The challenge quota overrides the server-wide one.
+func Quota(fschall *fs.Challenge) int64 {
+	if fschall.SourceQuota > 0 {
+		return fschall.SourceQuota
+	}
+	return global.Conf.Quota
+}
+
+// SourceInstances returns the challenges of which a source holds an instance,
+// whatever its status.
+func SourceInstances(sourceID string) ([]string, error) {
+	challs, err := fs.ListChallenges()
+	if err != nil {
+		return nil, err
+	}
+	held := []string{}
+	for _, challengeID := range challs {
+		if _, err := fs.FindInstance(challengeID, sourceID); err != nil {
+			if _, ok := err.(*errs.InstanceExist); ok {
+				continue
+			}
+			return nil, err
+		}
+		held = append(held, challengeID)
+	}
+	return held, nil
+}
+
+// CheckQuota returns an [*errs.QuotaExceeded] if the source can't hold one
+// more instance, i.e. to create one of the challenge.
+// It must be called with the source RW lock held, until the instance is
+// claimed, such that concurrent requests can't exceed the quota.
+func CheckQuota(fschall *fs.Challenge, sourceID string) error {
+	quota := Quota(fschall)
+	if quota <= 0 {
+		return nil
+	}
+	held, err := SourceInstances(sourceID)
+	if err != nil {
+		return err
+	}
+	if int64(len(held)) >= quota {
+		return &errs.QuotaExceeded{
+			ChallengeID: fschall.ID,
+			SourceID:    sourceID,
+			Quota:       quota,
+			Instances:   held,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package instance
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+	"github.com/ctfer-io/chall-manager/pkg/probe"
+)
+
+// awaitReadiness waits for a freshly deployed instance to pass its readiness
+// probes, if any, up to the configured timeout.
+// If they don't pass in time, the instance is marked as starting, and the
+// leader marks it as ready once they do (see RunReadiness).
+func awaitReadiness(ctx context.Context, fsist *fs.Instance) {
+	if len(fsist.Readiness) == 0 {
+		return
+	}
+
+	timeout := global.Conf.Readiness.Timeout
+	if timeout <= 0 {
+		fsist.SetStatus(fs.StatusStarting, "waiting for readiness probes")
+		return
+	}
+
+	rctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := probe.WaitAll(rctx, fsist.Readiness, time.Second); err != nil {
+		global.Log().Warn(ctx, "instance not ready in time",
+			zap.Error(err),
+		)
+		fsist.SetStatus(fs.StatusStarting, "readiness probes not passing yet: "+err.Error())
+	}
+}
+
+// RunReadiness periodically checks the readiness probes of starting
+// instances, until the context is done.
+// It must only run on the leader (see leader.Run).
+func RunReadiness(ctx context.Context, interval time.Duration) {
+	logger := global.Log()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		challs, err := fs.ListChallenges()
+		if err != nil {
+			logger.Error(ctx, "listing challenges", zap.Error(err))
+			continue
+		}
+		for _, challengeID := range challs {
+			if err := CheckReadiness(ctx, challengeID); err != nil {
+				logger.Error(global.WithChallengeID(ctx, challengeID), "checking instances readiness",
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// CheckReadiness marks the starting instances of a challenge as ready once
+// their readiness probes pass.
+func CheckReadiness(ctx context.Context, challengeID string) error {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, challengeID)
+
+	ctx, span := global.Tracer.Start(ctx, "check-readiness", trace.WithAttributes(
+		attribute.String("challenge_id", challengeID),
+	))
+	defer span.End()
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		return err
+	}
+	if err := totw.RLock(ctx); err != nil {
+		return err
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	if err := clock.RLock(ctx); err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. Check starting instances
+	//    (peek without lock, then check again once locked)
+	ists, err := fs.ListInstances(challengeID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // deleted in the meantime
+		}
+		return err
+	}
+	for _, identity := range ists {
+		fsist, err := fs.LoadInstance(challengeID, identity)
+		if err != nil {
+			if _, ok := err.(*errs.InstanceExist); ok {
+				continue
+			}
+			return err
+		}
+		if fsist.Status != fs.StatusStarting {
+			continue
+		}
+		if err := checkInstanceReadiness(ctx, challengeID, identity); err != nil {
+			// Don't let one instance hold back the others
+			logger.Error(global.WithIdentity(ctx, identity), "checking instance readiness",
+				zap.Error(err),
+			)
+		}
+	}
+	return nil
+}
+
+// checkInstanceReadiness marks a starting instance as ready if its readiness
+// probes pass. It must be called with the challenge R lock held.
+func checkInstanceReadiness(ctx context.Context, challengeID, identity string) error {
+	logger := global.Log()
+	ctx = global.WithIdentity(ctx, identity)
+
+	ilock, err := common.LockInstance(ctx, challengeID, identity)
+	if err != nil {
+		return err
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		return err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	fsist, err := fs.LoadInstance(challengeID, identity)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil // deleted in the meantime
+		}
+		return err
+	}
+	if fsist.Status != fs.StatusStarting {
+		return nil
+	}
+
+	if err := probe.CheckAll(ctx, fsist.Readiness); err != nil {
+		msg := "readiness probes not passing yet: " + err.Error()
+		if fsist.StatusMessage == msg {
+			return nil
+		}
+		fsist.SetStatus(fs.StatusStarting, msg)
+		return fsist.Save()
+	}
+
+	fsist.SetStatus(fs.StatusReady, "")
+	if err := fsist.Save(); err != nil {
+		return err
+	}
+	logger.Info(ctx, "instance is ready")
+
+	claims, err := fs.LookupClaims(challengeID, identity)
+	if err != nil {
+		return nil // pooled, nobody to tell
+	}
+	for _, sourceID := range claims {
+		common.EmitInstance(ctx, events.TypeInstanceUpdated, fsist, sourceID)
+	}
+	return nil
+}
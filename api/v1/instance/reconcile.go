@@ -0,0 +1,143 @@
+package instance
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/leader"
+)
+
+// reconcilePrefix is the etcd prefix under which the replicas forward their
+// reconcile requests to the leader.
+const reconcilePrefix = "/chall-manager/pool/reconcile/"
+
+// requested tracks the reconciliations running on this replica, such that
+// concurrent requests are served at once: the reconciliation runs again once
+// done if requested meanwhile, as it may have already read the pool.
+var requested = struct {
+	sync.Mutex
+	m map[string]bool // challenge ID -> requested again meanwhile
+}{
+	m: map[string]bool{},
+}
+
+// RequestReconcile requests the pool of a challenge to be reconciled, without
+// waiting for it.
+// Only the leader spins up pooled instances, such that replicas never decide
+// to refill a pool concurrently. Other replicas forward their requests to it.
+func RequestReconcile(ctx context.Context, challengeID string) {
+	ctx = context.WithoutCancel(ctx)
+	ctx = global.WithoutSourceID(ctx)
+	ctx = global.WithoutIdentity(ctx)
+
+	if !leader.IsLeader() {
+		if _, err := global.GetEtcdManager().Put(ctx, reconcilePrefix+fs.Hash(challengeID), challengeID); err != nil {
+			global.Log().Error(ctx, "forwarding reconcile request to leader",
+				zap.Error(err),
+			)
+		}
+		return
+	}
+
+	requested.Lock()
+	if _, running := requested.m[challengeID]; running {
+		requested.m[challengeID] = true
+		requested.Unlock()
+		return
+	}
+	requested.m[challengeID] = false
+	requested.Unlock()
+
+	go func() {
+		for again := true; again; {
+			if err := Reconcile(ctx, challengeID); err != nil {
+				global.Log().Error(ctx, "reconciling pool",
+					zap.Error(err),
+				)
+			}
+
+			requested.Lock()
+			again = requested.m[challengeID]
+			if again {
+				requested.m[challengeID] = false
+			} else {
+				delete(requested.m, challengeID)
+			}
+			requested.Unlock()
+		}
+	}()
+}
+
+// WatchReconcileRequests serves the reconcile requests forwarded by other
+// replicas, until the context is done. It must only run on the leader.
+func WatchReconcileRequests(ctx context.Context) {
+	if global.Conf.Etcd.Endpoint == "" {
+		return // single replica, nothing to forward
+	}
+
+	logger := global.Log()
+	for {
+		if err := watchReconcileRequests(ctx); err != nil {
+			logger.Error(ctx, "watching reconcile requests", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func watchReconcileRequests(ctx context.Context) error {
+	man := global.GetEtcdManager()
+
+	// Serve requests forwarded while there was no leader
+	res, err := man.Get(ctx, reconcilePrefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	for _, kv := range res.Kvs {
+		serveReconcileRequest(ctx, string(kv.Key), string(kv.Value))
+	}
+
+	wch, err := man.Watch(ctx, reconcilePrefix, clientv3.WithPrefix(), clientv3.WithRev(res.Header.Revision+1))
+	if err != nil {
+		return err
+	}
+	for wres := range wch {
+		if err := wres.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wres.Events {
+			if ev.Type == clientv3.EventTypePut {
+				serveReconcileRequest(ctx, string(ev.Kv.Key), string(ev.Kv.Value))
+			}
+		}
+	}
+	return nil
+}
+
+func serveReconcileRequest(ctx context.Context, key, challengeID string) {
+	if _, err := global.GetEtcdManager().Delete(ctx, key); err != nil {
+		global.Log().Error(ctx, "acknowledging reconcile request", zap.Error(err))
+	}
+	RequestReconcile(ctx, challengeID)
+}
+
+// RunBackground runs the pool background jobs until the context is done:
+// recovering the interrupted instances, resuming the pending operations,
+// serving the reconcile requests and reconciling all pools periodically.
+// It must only run on the leader (see leader.Run).
+func RunBackground(ctx context.Context, interval time.Duration) {
+	RecoverAll(ctx)
+	ResumePending(ctx)
+	go WatchReconcileRequests(ctx)
+	RunScheduler(ctx, interval)
+}
@@ -0,0 +1,312 @@
+package instance
+
+import (
+	"context"
+	"os"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+// reasonOrphan is the recovery reason of a stack that has no instance.
+const reasonOrphan = "orphan"
+
+// startedAt is the date chall-manager started. The stacks last deployed before
+// it that are not known of the filesystem are orphans of a previous run.
+var startedAt = time.Now()
+
+func (man *Manager) RecoverInstances(req *RecoverInstancesRequest, server InstanceManager_RecoverInstancesServer) error {
+	logger := global.Log()
+	ctx := server.Context()
+
+	policy := global.Conf.Recovery.Policy
+	if req.Policy != nil {
+		policy = req.GetPolicy().String()
+	}
+
+	challs := []string{}
+	if req.ChallengeId != nil {
+		ctx = global.WithChallengeID(ctx, req.GetChallengeId())
+		challs = append(challs, req.GetChallengeId())
+	} else {
+		ids, err := fs.ListChallenges()
+		if err != nil {
+			logger.Error(ctx, "listing challenges", zap.Error(err))
+			return errs.ErrInternalNoSub
+		}
+		challs = ids
+	}
+
+	for _, challengeID := range challs {
+		err := RecoverChallenge(ctx, challengeID, policy, server.Send)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return errs.ErrCanceled
+		}
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			if req.ChallengeId != nil {
+				return err
+			}
+			continue // deleted in the meantime
+		}
+		logger.Error(global.WithChallengeID(ctx, challengeID), "recovering instances",
+			zap.Error(err),
+		)
+		return errs.ErrInternalNoSub
+	}
+	return nil
+}
+
+// RecoverAll recovers the instances of all challenges with the configured
+// policy (see RecoverChallenge).
+// It should be called once elected, such that the operations interrupted by
+// the previous leader are dealt with.
+func RecoverAll(ctx context.Context) {
+	logger := global.Log()
+
+	challs, err := fs.ListChallenges()
+	if err != nil {
+		logger.Error(ctx, "listing challenges", zap.Error(err))
+		return
+	}
+	for _, challengeID := range challs {
+		ctx := global.WithChallengeID(ctx, challengeID)
+		if err := RecoverChallenge(ctx, challengeID, global.Conf.Recovery.Policy, func(rec *RecoveredInstance) error {
+			logger.Info(ctx, "recovered instance",
+				zap.String("identity", rec.Identity),
+				zap.String("reason", rec.Reason),
+				zap.Stringer("policy", rec.Policy),
+				zap.Bool("success", rec.Success),
+			)
+			return nil
+		}); err != nil {
+			if _, ok := err.(*errs.ChallengeExist); ok {
+				continue
+			}
+			logger.Error(ctx, "recovering instances", zap.Error(err))
+		}
+	}
+}
+
+// RecoverChallenge recovers the instances of a challenge that have been left
+// inconsistent by an interrupted stack update or destroy, i.e., whose state
+// contains pending operations or is missing. They are then resumed or
+// destroyed according to the policy.
+// The stacks of the challenge scenario that have no instance, i.e., that never
+// got saved, are destroyed.
+// Every recovered instance is passed to send, and an error from it stops the
+// recovery.
+func RecoverChallenge(ctx context.Context, challengeID, policy string, send func(*RecoveredInstance) error) error {
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, challengeID)
+
+	ctx, span := global.Tracer.Start(ctx, "recover-instances", trace.WithAttributes(
+		attribute.String("challenge_id", challengeID),
+		attribute.String("policy", policy),
+	))
+	defer span.End()
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		return err
+	}
+	if err := totw.RLock(ctx); err != nil {
+		return err
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock RW challenge, such that no instance is created in the meantime
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	if err := clock.RWLock(ctx); err != nil {
+		return multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge RW unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. Load challenge, it could have been deleted in the meantime
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		return err
+	}
+
+	// Interrupting Pulumi would leave the stacks inconsistent once more
+	wctx := context.WithoutCancel(ctx)
+	destroyed := false
+
+	// 5. Destroy orphan stacks
+	orphans, err := iac.Orphans(ctx, fschall.Scenario, startedAt)
+	if err != nil {
+		return err
+	}
+	if len(orphans) != 0 {
+		known, err := knownIdentities()
+		if err != nil {
+			return err
+		}
+		for _, id := range orphans {
+			if slices.Contains(known, id) {
+				continue
+			}
+
+			ctx := global.WithIdentity(ctx, id)
+			logger.Warn(ctx, "destroying orphan stack")
+
+			err := iac.DestroyOrphan(wctx, fschall, id)
+			if err != nil {
+				logger.Error(ctx, "destroying orphan stack", zap.Error(err))
+			}
+			if err := send(&RecoveredInstance{
+				ChallengeId: challengeID,
+				Identity:    id,
+				Reason:      reasonOrphan,
+				Policy:      RecoveryPolicy_destroy,
+				Success:     err == nil,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 6. Recover instances
+	ists, err := fs.ListInstances(challengeID)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, identity := range ists {
+		rec, err := recoverInstance(ctx, fschall, identity, policy)
+		if err != nil {
+			return err
+		}
+		if rec == nil {
+			continue
+		}
+		if rec.Success && rec.Policy == RecoveryPolicy_destroy {
+			destroyed = true
+		}
+		if err := send(rec); err != nil {
+			return err
+		}
+	}
+
+	// Refill the pool if instances were destroyed
+	if destroyed {
+		RequestReconcile(ctx, challengeID)
+	}
+	return nil
+}
+
+// recoverInstance recovers an instance if it requires to.
+// It must be called with the challenge RW lock held.
+func recoverInstance(ctx context.Context, fschall *fs.Challenge, identity, policy string) (*RecoveredInstance, error) {
+	logger := global.Log()
+	ctx = global.WithIdentity(ctx, identity)
+
+	// Lock RW instance, an operation could still be running on it
+	ilock, err := common.LockInstance(ctx, fschall.ID, identity)
+	if err != nil {
+		return nil, err
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		return nil, err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	fsist, err := fs.LoadInstance(fschall.ID, identity)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil, nil // deleted in the meantime
+		}
+		return nil, err
+	}
+	reason := iac.NeedsRecovery(fsist)
+	if reason == "" {
+		return nil, nil
+	}
+
+	sourceID, err := fs.LookupClaim(fschall.ID, identity)
+	if err != nil {
+		if ierr, ok := err.(*errs.InstanceExist); !ok || ierr.Exist {
+			return nil, err
+		}
+		// no claim file => in pool
+	} else {
+		ctx = global.WithSourceID(ctx, sourceID)
+	}
+
+	logger.Warn(ctx, "recovering instance",
+		zap.String("reason", reason),
+		zap.String("policy", policy),
+	)
+	err = iac.Recover(context.WithoutCancel(ctx), policy, fschall, fsist)
+	if err != nil {
+		logger.Error(ctx, "recovering instance", zap.Error(err))
+	} else if policy == iac.PolicyDestroy {
+		common.InstancesUDCounter().Add(ctx, -1,
+			metric.WithAttributeSet(common.InstanceAttrs(fschall.ID, sourceID, sourceID == "")),
+		)
+	}
+
+	return &RecoveredInstance{
+		ChallengeId: fschall.ID,
+		Identity:    identity,
+		SourceId:    sourceID,
+		Reason:      reason,
+		Policy:      RecoveryPolicy(RecoveryPolicy_value[policy]),
+		Success:     err == nil,
+	}, nil
+}
+
+// knownIdentities lists the identities of the instances of all challenges.
+func knownIdentities() ([]string, error) {
+	challs, err := fs.ListChallenges()
+	if err != nil {
+		return nil, err
+	}
+	known := []string{}
+	for _, challengeID := range challs {
+		ists, err := fs.ListInstances(challengeID)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		known = append(known, ists...)
+	}
+	return known, nil
+}
@@ -2,6 +2,7 @@ package instance
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"go.opentelemetry.io/otel/trace"
@@ -15,6 +16,7 @@ import (
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/events"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/lock"
 )
@@ -101,7 +103,19 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 		)
 		return nil, errs.ErrInternalNoSub
 	}
-	id, err := fs.FindInstance(req.GetChallengeId(), req.GetSourceId())
+	// 5-8. Renew the instance
+	// 9. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+	return renewInstance(ctx, fschall, req.GetSourceId())
+}
+
+// renewInstance renews the instance of a source.
+// It must be called with the challenge R lock held.
+func renewInstance(ctx context.Context, fschall *fs.Challenge, sourceID string) (*Instance, error) {
+	logger := global.Log()
+	ctx = global.WithSourceID(ctx, sourceID)
+
+	id, err := fs.FindInstance(fschall.ID, sourceID)
 	if err != nil {
 		if _, ok := err.(*errs.InstanceExist); ok {
 			return nil, err
@@ -112,9 +126,8 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 	}
 
 	// 5. Lock RW instance
-	ctx = global.WithSourceID(ctx, req.GetSourceId())
 	ctx = global.WithIdentity(ctx, id)
-	ilock, err := common.LockInstance(ctx, req.GetChallengeId(), id)
+	ilock, err := common.LockInstance(ctx, fschall.ID, id)
 	if err != nil {
 		if ilock.IsCanceled(err) {
 			return nil, errs.ErrCanceled
@@ -136,7 +149,7 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 	}(ilock)
 
 	// 6. If instance does not exist, return error (+ Unlock RW instance, Unlock R challenge)
-	fsist, err := fs.LoadInstance(req.GetChallengeId(), id)
+	fsist, err := fs.LoadInstance(fschall.ID, id)
 	if err != nil {
 		logger.Error(ctx, "loading challenge instance",
 			zap.Error(err),
@@ -152,7 +165,7 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 				Reason: errs.ReasonChallengeNoRenewal,
 				Domain: errs.Domain,
 				Metadata: map[string]string{
-					"id": req.GetChallengeId(),
+					"id": fschall.ID,
 				},
 			},
 			&errdetails.PreconditionFailure{
@@ -179,8 +192,8 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 				Reason: errs.ReasonInstanceExpired,
 				Domain: errs.Domain,
 				Metadata: map[string]string{
-					"challenge_id": req.GetChallengeId(),
-					"source_id":    req.GetSourceId(),
+					"challenge_id": fschall.ID,
+					"source_id":    sourceID,
 				},
 			},
 			&errdetails.PreconditionFailure{
@@ -199,8 +212,94 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 		return nil, st.Err()
 	}
 
+	if fschall.MaxRenewals > 0 && fsist.Renewals >= fschall.MaxRenewals {
+		// This makes sure renewals are capped
+		st, err := status.New(codes.FailedPrecondition, "Instance can't be renewed anymore.").WithDetails(
+			&errdetails.ErrorInfo{
+				Reason: errs.ReasonInstanceMaxRenewals,
+				Domain: errs.Domain,
+				Metadata: map[string]string{
+					"challenge_id": fschall.ID,
+					"source_id":    sourceID,
+					"max_renewals": fmt.Sprintf("%d", fschall.MaxRenewals),
+				},
+			},
+			&errdetails.PreconditionFailure{
+				Violations: []*errdetails.PreconditionFailure_Violation{
+					{
+						Type:        "RENEWAL",
+						Subject:     errs.Domain + "/Instance",
+						Description: fmt.Sprintf("Instance has already been renewed %d times out of %d.", fsist.Renewals, fschall.MaxRenewals),
+					},
+				},
+			},
+		)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to build error: %v", err)
+		}
+		return nil, st.Err()
+	}
+
+	// The lifetime counts from the claim for pooled instances (see fs.Instance.Handover)
+	if deadline := fschall.Deadline(fsist.Since); deadline != nil && (!now.Before(*deadline) || (fsist.Until != nil && !fsist.Until.Before(*deadline))) {
+		// This makes sure renewal does not extend the instance past its lifetime
+		st, err := status.New(codes.FailedPrecondition, "Instance can't be renewed as it reached its maximum lifetime.").WithDetails(
+			&errdetails.ErrorInfo{
+				Reason: errs.ReasonInstanceMaxLifetime,
+				Domain: errs.Domain,
+				Metadata: map[string]string{
+					"challenge_id": fschall.ID,
+					"source_id":    sourceID,
+					"deadline":     deadline.Format(time.RFC3339),
+				},
+			},
+			&errdetails.PreconditionFailure{
+				Violations: []*errdetails.PreconditionFailure_Violation{
+					{
+						Type:        "LIFETIME",
+						Subject:     errs.Domain + "/Instance",
+						Description: "Instance can not live past " + deadline.Format(time.RFC3339) + ".",
+					},
+				},
+			},
+		)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to build error: %v", err)
+		}
+		return nil, st.Err()
+	}
+
+	if fschall.RenewWindow != nil && fsist.Until != nil && fsist.Until.Sub(now) > *fschall.RenewWindow {
+		// This makes sure renewal only happens close to the expiration
+		st, err := status.New(codes.FailedPrecondition, "Instance can't be renewed yet.").WithDetails(
+			&errdetails.ErrorInfo{
+				Reason: errs.ReasonInstanceRenewWindow,
+				Domain: errs.Domain,
+				Metadata: map[string]string{
+					"challenge_id": fschall.ID,
+					"source_id":    sourceID,
+					"renew_window": fschall.RenewWindow.String(),
+				},
+			},
+			&errdetails.PreconditionFailure{
+				Violations: []*errdetails.PreconditionFailure_Violation{
+					{
+						Type:        "RENEWAL_WINDOW",
+						Subject:     errs.Domain + "/Instance",
+						Description: "Instance can only be renewed within " + fschall.RenewWindow.String() + " before it expires.",
+					},
+				},
+			},
+		)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to build error: %v", err)
+		}
+		return nil, st.Err()
+	}
+
 	fsist.LastRenew = now
-	fsist.Until = common.ComputeUntil(fschall.Until, fschall.Timeout)
+	fsist.Until = common.InstanceUntil(fschall, fsist.Since)
+	fsist.Renewals++
 
 	logger.Info(ctx, "renewing instance")
 	if err := fsist.Save(); err != nil {
@@ -209,19 +308,18 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 		)
 		return nil, errs.ErrInternalNoSub
 	}
+	common.EmitInstance(ctx, events.TypeInstanceRenewed, fsist, sourceID)
 
 	// 8. Unlock RW instance
 	//    -> defered after 5 (fault-tolerance)
-	// 9. Unlock R challenge
-	//    -> defered after 2 (fault-tolerance)
 
 	var until *timestamppb.Timestamp
 	if fsist.Until != nil {
 		until = timestamppb.New(*fsist.Until)
 	}
 	return &Instance{
-		ChallengeId:    req.GetChallengeId(),
-		SourceId:       req.GetSourceId(),
+		ChallengeId:    fschall.ID,
+		SourceId:       sourceID,
 		Since:          timestamppb.New(fsist.Since),
 		LastRenew:      timestamppb.New(fsist.LastRenew),
 		Until:          until,
@@ -232,6 +330,9 @@ func (man *Manager) RenewInstance(ctx context.Context, req *RenewInstanceRequest
 			}
 			return nil
 		}(),
-		Flags: fsist.Flags,
+		Flags:         fsist.Flags,
+		Status:        Status(fsist),
+		StatusMessage: fsist.StatusMessage,
+		Renewals:      fsist.Renewals,
 	}, nil
 }
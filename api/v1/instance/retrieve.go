@@ -179,7 +179,10 @@ func (man *Manager) RetrieveInstance(ctx context.Context, req *RetrieveInstanceR
 			}
 			return nil
 		}(),
-		Flags:      fsist.Flags,
-		Additional: fsist.Additional,
+		Flags:         fsist.Flags,
+		Additional:    fsist.Additional,
+		Status:        Status(fsist),
+		StatusMessage: fsist.StatusMessage,
+		Renewals:      fsist.Renewals,
 	}, nil
 }
@@ -0,0 +1,116 @@
+package instance
+
+import (
+	"context"
+	"maps"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+// joinShared claims for a source an instance of a shared challenge that is
+// claimed by fewer sources than the challenge allows, and was deployed with
+// the same additional values.
+// It returns the joined instance, or nil if there is none to join.
+// It must be called with the challenge R lock and shares RW lock held, such
+// that no instance is deployed meanwhile.
+func joinShared(ctx context.Context, fschall *fs.Challenge, sourceID string, additional map[string]string) (*fs.Instance, error) {
+	ists, err := fs.ListInstances(fschall.ID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, identity := range ists {
+		fsist, err := join(ctx, fschall, identity, sourceID, additional)
+		if err != nil || fsist != nil {
+			return fsist, err
+		}
+	}
+	return nil, nil
+}
+
+// join claims an instance for a source if it can be shared with it.
+func join(ctx context.Context, fschall *fs.Challenge, identity, sourceID string, additional map[string]string) (*fs.Instance, error) {
+	claims, err := fs.LookupClaims(fschall.ID, identity)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil, nil // in pool
+		}
+		return nil, err
+	}
+	if !fschall.SharedBy(len(claims)) {
+		return nil, nil
+	}
+
+	// Lock RW instance, such that it is not deleted meanwhile
+	ctx = global.WithIdentity(ctx, identity)
+	ilock, err := common.LockInstance(ctx, fschall.ID, identity)
+	if err != nil {
+		return nil, err
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		return nil, err
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			global.Log().Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// Sources may have left before we locked it
+	fsist, err := fs.LoadInstance(fschall.ID, identity)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil, nil // deleted meanwhile
+		}
+		return nil, err
+	}
+	claims, err = fs.LookupClaims(fschall.ID, identity)
+	if err != nil {
+		return nil, err
+	}
+	if !fschall.SharedBy(len(claims)) || !maps.Equal(fsist.Additional, additional) {
+		return nil, nil
+	}
+	if st := Status(fsist); st != InstanceStatus_ready && st != InstanceStatus_paused {
+		return nil, nil
+	}
+
+	if err := fsist.Claim(sourceID); err != nil {
+		return nil, err
+	}
+	return fsist, nil
+}
+
+// CountClaims returns the number of sources that claim each claimed instance
+// of a challenge.
+// It must be called with the challenge lock held.
+func CountClaims(challengeID string) (map[string]int, error) {
+	ists, err := fs.ListInstances(challengeID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	claims := map[string]int{}
+	for _, identity := range ists {
+		sourceIDs, err := fs.LookupClaims(challengeID, identity)
+		if err != nil {
+			if ierr, ok := err.(*errs.InstanceExist); ok && !ierr.Exist {
+				continue // in pool
+			}
+			return nil, err
+		}
+		claims[identity] = len(sourceIDs)
+	}
+	return claims, nil
+}
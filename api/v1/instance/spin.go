@@ -2,13 +2,17 @@ package instance
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/ctfer-io/chall-manager/api/v1/common"
 	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
 	"github.com/ctfer-io/chall-manager/pkg/fs"
 	"github.com/ctfer-io/chall-manager/pkg/iac"
 	"github.com/ctfer-io/chall-manager/pkg/identity"
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+	"github.com/ctfer-io/chall-manager/pkg/probe"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
@@ -17,9 +21,13 @@ import (
 )
 
 // SpinUp is a function that creates a brand new instance in the pool of a challenge.
+// The variant selects the pool, the default one being empty.
+// The operation is persisted until it completes, such that it is resumed if
+// chall-manager stops in the meantime (see ResumePending).
 // Is must be called in a goroutine as it relocks the TOTW/challenge locks.
-func SpinUp(ctx context.Context, challengeID string) {
-	logger := global.Log()
+func SpinUp(ctx context.Context, challengeID, variant string) {
+	addInflight(challengeID, variant, 1)
+	defer addInflight(challengeID, variant, -1)
 
 	// Reset context to acceptable state
 	ctx = context.WithoutCancel(ctx)
@@ -27,40 +35,88 @@ func SpinUp(ctx context.Context, challengeID string) {
 	ctx = global.WithoutSourceID(ctx)
 	ctx = global.WithoutIdentity(ctx)
 
+	done := pushPending(ctx, challengeID, variant)
+	defer done()
+
 	// Track span of spinning up a new instance
 	ctx, span := global.Tracer.Start(ctx, "pool-spin-up", trace.WithAttributes(
 		attribute.String("challenge_id", challengeID),
+		attribute.String("variant", variant),
 	))
 	defer span.End()
 
+	// Don't hammer the infrastructure if spin-ups keep failing
+	allowed := false
+	if err := updatePoolState(ctx, challengeID, func(st *poolState) {
+		allowed = st.Breaker.Allow(time.Now())
+	}); err != nil {
+		global.Log().Error(ctx, "checking pool circuit breaker", zap.Error(err))
+		return
+	}
+	if !allowed {
+		global.Log().Debug(ctx, "pool circuit breaker is backing off, skipping spin-up")
+		return
+	}
+
+	// Keep track of failures for pool status and circuit breaker
+	outcome := spinUp(ctx, challengeID, variant)
+	if outcome != nil && outcome != errSkipped {
+		span.RecordError(outcome)
+	}
+	if err := updatePoolState(ctx, challengeID, func(st *poolState) {
+		switch outcome {
+		case nil:
+			st.Breaker.Success()
+		case errSkipped:
+			st.Breaker.Cancel()
+		default:
+			now := time.Now()
+			st.Stats.Failure(now, outcome)
+			st.Breaker.Failure(now)
+		}
+		recordBreaker(ctx, challengeID, st.Breaker)
+	}); err != nil {
+		global.Log().Error(ctx, "recording pool circuit breaker outcome", zap.Error(err))
+	}
+}
+
+// errSkipped is returned when a spin-up did not happen, though it did not fail.
+var errSkipped = errors.New("spin-up skipped")
+
+func spinUp(ctx context.Context, challengeID, variant string) error {
+	logger := global.Log()
+	span := trace.SpanFromContext(ctx)
+
 	// 1. Lock R TOTW
 	span.AddEvent("lock TOTW")
 	totw, err := common.LockTOTW(ctx)
 	if err != nil {
 		logger.Error(ctx, "build TOTW lock", zap.Error(err))
-		return
+		return err
 	}
 	if err := totw.RLock(ctx); err != nil {
 		logger.Error(ctx, "TOTW R lock", zap.Error(err))
-		return
+		return err
 	}
 	span.AddEvent("locked TOTW")
 
 	// 2. Lock R challenge
 	clock, err := common.LockChallenge(ctx, challengeID)
 	if err != nil {
-		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+		err = multierr.Combine(
 			totw.RUnlock(ctx),
 			err,
-		)))
-		return
+		)
+		logger.Error(ctx, "build challenge lock", zap.Error(err))
+		return err
 	}
 	if err := clock.RLock(ctx); err != nil {
-		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+		err = multierr.Combine(
 			totw.RUnlock(ctx),
 			err,
-		)))
-		return
+		)
+		logger.Error(ctx, "challenge R lock", zap.Error(err))
+		return err
 	}
 	defer func() {
 		if err := clock.RUnlock(ctx); err != nil {
@@ -75,7 +131,7 @@ func SpinUp(ctx context.Context, challengeID string) {
 		logger.Error(ctx, "TOTW R unlock",
 			zap.Error(err),
 		)
-		return
+		return err
 	}
 	span.AddEvent("unlocked TOTW")
 
@@ -83,35 +139,57 @@ func SpinUp(ctx context.Context, challengeID string) {
 	fschall, err := fs.LoadChallenge(challengeID)
 	if err != nil {
 		logger.Error(ctx, "loading challenge",
-			zap.Error(multierr.Combine(
-				clock.RUnlock(ctx),
-				err,
-			)),
+			zap.Error(err),
 		)
-		return
+		return err
 	}
 	// Skip pre-provision if challenge is expired
 	if fschall.Until != nil && time.Now().After(*fschall.Until) {
-		return
+		return errSkipped
+	}
+	// Skip pre-provision if the variant has been removed in the meantime
+	var additional map[string]string
+	if variant != "" {
+		v, ok := pool.Find(fschall.Variants, variant)
+		if !ok {
+			return errSkipped
+		}
+		additional = v.Additional
+	}
+
+	// Skip pre-provision if there is no capacity left
+	release, err := ReserveBudget(ctx, fschall)
+	if err != nil {
+		if _, ok := err.(*errs.BudgetExhausted); ok {
+			logger.Info(ctx, "capacity budget exhausted, skipping pool spin-up")
+			return errSkipped
+		}
+		logger.Error(ctx, "reserving capacity budget",
+			zap.Error(err),
+		)
+		return err
 	}
+	defer release()
 
 	// 5. Create identity
 	id := identity.New()
 	ctx = global.WithIdentity(ctx, id)
+	defer iac.Deploying(id)()
 
 	// 10. Spin up instance
+	start := time.Now()
 	stack, err := iac.NewStack(ctx, fschall, id)
 	if err != nil {
 		logger.Error(ctx, "building new stack",
 			zap.Error(err),
 		)
-		return
+		return err
 	}
-	if err := iac.Additional(ctx, stack, fschall.Additional, nil); err != nil {
+	if err := iac.Additional(ctx, stack, fschall.Additional, additional); err != nil {
 		logger.Error(ctx, "configuring additionals on stack",
 			zap.Error(err),
 		)
-		return
+		return err
 	}
 
 	sr, err := stack.Up(ctx)
@@ -119,7 +197,7 @@ func SpinUp(ctx context.Context, challengeID string) {
 		logger.Error(ctx, "stack up",
 			zap.Error(err),
 		)
-		return
+		return err
 	}
 
 	now := time.Now()
@@ -128,14 +206,44 @@ func SpinUp(ctx context.Context, challengeID string) {
 		ChallengeID: challengeID,
 		Since:       now,
 		LastRenew:   now,
-		Until:       common.ComputeUntil(fschall.Until, fschall.Timeout),
-		Additional:  nil,
+		Until:       common.InstanceUntil(fschall, now),
+		Additional:  additional,
+		Variant:     variant,
+		Status:      fs.StatusReady,
 	}
 	if err := stack.Export(ctx, sr, fsist); err != nil {
 		logger.Error(ctx, "extracting stack info",
 			zap.Error(err),
 		)
-		return
+		return err
+	}
+
+	// Don't register in pool until healthy, elseway it could be claimed
+	// while still starting, or considered broken.
+	if fsist.Healthcheck != "" {
+		hctx, cancel := context.WithTimeout(ctx, global.Conf.Pool.HealthcheckTimeout)
+		err := probe.Wait(hctx, fsist.Healthcheck, time.Second)
+		cancel()
+		if err != nil {
+			err = multierr.Combine(
+				err,
+				stack.Down(ctx),
+			)
+			logger.Error(ctx, "pooled instance never got healthy",
+				zap.Error(err),
+			)
+			return err
+		}
+	}
+	// Neither mark it ready until its readiness probes pass, elseway it is
+	// claimed as ready while it is not
+	awaitReadiness(ctx, fsist)
+	dur := time.Since(start)
+	if err := updatePoolState(ctx, challengeID, func(st *poolState) {
+		st.Demand.SpinUp(dur)
+		st.Stats.Success(dur)
+	}); err != nil {
+		logger.Error(ctx, "recording pool spin-up", zap.Error(err))
 	}
 
 	logger.Info(ctx, "instance registered in pool")
@@ -148,5 +256,7 @@ func SpinUp(ctx context.Context, challengeID string) {
 		logger.Error(ctx, "exporting instance information to filesystem",
 			zap.Error(err),
 		)
+		return err
 	}
+	return nil
 }
@@ -0,0 +1,9 @@
+package instance
+
+import "github.com/ctfer-io/chall-manager/pkg/fs"
+
+// Status returns the API status of an instance.
+func Status(fsist *fs.Instance) InstanceStatus {
+	// An empty status, i.e., an instance saved before statuses existed, is ready
+	return InstanceStatus(InstanceStatus_value[fsist.Status])
+}
@@ -0,0 +1,243 @@
+package instance
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/lock"
+)
+
+func (man *Manager) TransferInstance(ctx context.Context, req *TransferInstanceRequest) (*Instance, error) {
+	challengeID, fromSourceID, toSourceID := req.GetChallengeId(), req.GetFromSourceId(), req.GetToSourceId()
+	logger := global.Log()
+	ctx = global.WithChallengeID(ctx, challengeID)
+	span := trace.SpanFromContext(ctx)
+
+	// 1. Lock R TOTW
+	span.AddEvent("lock TOTW")
+	totw, err := common.LockTOTW(ctx)
+	if err != nil {
+		if totw.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "build TOTW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := totw.RLock(ctx); err != nil {
+		if totw.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "TOTW R lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("locked TOTW")
+
+	// 2. Lock R challenge
+	clock, err := common.LockChallenge(ctx, challengeID)
+	if err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "recovering from build challenge lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+		}
+		logger.Error(ctx, "build challenge lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := clock.RLock(ctx); err != nil {
+		if clock.IsCanceled(err) {
+			// If canceled, we need to recover
+			if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "recovering from challenge R lock", zap.Error(err))
+				return nil, errs.ErrInternalNoSub
+			}
+			return nil, errs.ErrCanceled // recovery is successful, we can quit safely
+		}
+		logger.Error(ctx, "challenge R lock", zap.Error(multierr.Combine(
+			totw.RUnlock(context.WithoutCancel(ctx)),
+			err,
+		)))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "challenge R unlock", zap.Error(err))
+		}
+	}(clock)
+
+	// 3. Unlock R TOTW
+	if err := totw.RUnlock(context.WithoutCancel(ctx)); err != nil {
+		logger.Error(ctx, "TOTW R unlock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	span.AddEvent("unlocked TOTW")
+
+	// 4. If challenge or instance does not exist, or the target source
+	// already holds an instance, return error
+	fschall, err := fs.LoadChallenge(challengeID)
+	if err != nil {
+		// If challenge not found
+		if _, ok := err.(*errs.ChallengeExist); ok {
+			return nil, err
+		}
+		// Else deal with it as an internal server error
+		logger.Error(ctx, "loading challenge",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	id, err := fs.FindInstance(challengeID, fromSourceID)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil, err
+		}
+
+		logger.Error(ctx, "finding instance", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	ctx = global.WithSourceID(ctx, fromSourceID)
+	ctx = global.WithIdentity(ctx, id)
+
+	// Lock RW target source if it has a quota, such that concurrent requests
+	// can't exceed it
+	if Quota(fschall) > 0 {
+		slock, err := common.LockSource(ctx, toSourceID)
+		if err != nil {
+			if slock.IsCanceled(err) {
+				return nil, errs.ErrCanceled
+			}
+			logger.Error(ctx, "build source lock", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		if err := slock.RWLock(ctx); err != nil {
+			if slock.IsCanceled(err) {
+				return nil, errs.ErrCanceled
+			}
+			logger.Error(ctx, "source RW lock", zap.Error(err))
+			return nil, errs.ErrInternalNoSub
+		}
+		defer func(lock lock.RWLock) {
+			if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+				logger.Error(ctx, "source RW unlock", zap.Error(err))
+			}
+		}(slock)
+	}
+
+	if _, err := fs.FindInstance(challengeID, toSourceID); err == nil {
+		return nil, &errs.InstanceExist{
+			ChallengeID: challengeID,
+			SourceID:    toSourceID,
+			Exist:       true,
+		}
+	} else if _, ok := err.(*errs.InstanceExist); !ok {
+		logger.Error(ctx, "finding instance", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := CheckQuota(fschall, toSourceID); err != nil {
+		if _, ok := err.(*errs.QuotaExceeded); ok {
+			logger.Warn(ctx, "target source quota exceeded")
+			return nil, err
+		}
+		logger.Error(ctx, "checking source quota", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+
+	// 5. Lock RW instance
+	ilock, err := common.LockInstance(ctx, challengeID, id)
+	if err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "build instance lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := ilock.RWLock(ctx); err != nil {
+		if ilock.IsCanceled(err) {
+			return nil, errs.ErrCanceled
+		}
+		logger.Error(ctx, "instance RW lock", zap.Error(err))
+		return nil, errs.ErrInternalNoSub
+	}
+	defer func(lock lock.RWLock) {
+		if err := lock.RWUnlock(context.WithoutCancel(ctx)); err != nil {
+			logger.Error(ctx, "instance RW unlock", zap.Error(err))
+		}
+	}(ilock)
+
+	// 6. Transfer the claim, the former source could have left meanwhile
+	fsist, err := fs.LoadInstance(challengeID, id)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil, &errs.InstanceExist{
+				ChallengeID: challengeID,
+				SourceID:    fromSourceID,
+				Exist:       false,
+			}
+		}
+		logger.Error(ctx, "loading challenge instance",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	if err := fsist.Transfer(fromSourceID, toSourceID); err != nil {
+		if _, ok := err.(*errs.InstanceExist); ok {
+			return nil, err
+		}
+		logger.Error(ctx, "transferring instance claim",
+			zap.Error(err),
+		)
+		return nil, errs.ErrInternalNoSub
+	}
+	logger.Info(ctx, "instance transferred",
+		zap.String("to_source_id", toSourceID),
+	)
+	common.InstancesUDCounter().Add(ctx, -1,
+		metric.WithAttributeSet(common.InstanceAttrs(challengeID, fromSourceID, false)),
+	)
+	common.InstancesUDCounter().Add(ctx, 1,
+		metric.WithAttributeSet(common.InstanceAttrs(challengeID, toSourceID, false)),
+	)
+	common.EmitTransfer(ctx, fsist, fromSourceID, toSourceID)
+
+	// 7. Unlock RW instance
+	//    -> defered after 5 (fault-tolerance)
+	// 8. Unlock R challenge
+	//    -> defered after 2 (fault-tolerance)
+
+	var until *timestamppb.Timestamp
+	if fsist.Until != nil {
+		until = timestamppb.New(*fsist.Until)
+	}
+	return &Instance{
+		ChallengeId:    challengeID,
+		SourceId:       toSourceID,
+		Since:          timestamppb.New(fsist.Since),
+		LastRenew:      timestamppb.New(fsist.LastRenew),
+		Until:          until,
+		ConnectionInfo: fsist.ConnectionInfo,
+		Flag: func() *string { // kept for retrocompatibility enough time for public migration
+			if len(fsist.Flags) == 1 {
+				return &fsist.Flags[0]
+			}
+			return nil
+		}(),
+		Flags:         fsist.Flags,
+		Status:        Status(fsist),
+		StatusMessage: fsist.StatusMessage,
+		Renewals:      fsist.Renewals,
+	}, nil
+}
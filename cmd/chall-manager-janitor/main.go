@@ -2,22 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net/http"
 	"net/mail"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
+	json "github.com/goccy/go-json"
 	"github.com/sony/gobreaker/v2"
 	"github.com/urfave/cli/v3"
 	"go.opentelemetry.io/contrib/bridges/otelzap"
 	"go.opentelemetry.io/contrib/exporters/autoexport"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	otelglobal "go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
@@ -32,9 +40,12 @@ import (
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/ctfer-io/chall-manager/api/v1/challenge"
 	"github.com/ctfer-io/chall-manager/api/v1/instance"
@@ -62,7 +73,7 @@ func main() {
 	cmd := &cli.Command{
 		Name:  "Chall-Manager-Janitor",
 		Usage: "Chall-Manager-Janitor is an utility that handles challenges instances death.",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.VersionFlag,
 			cli.HelpFlag,
 			&cli.StringFlag{
@@ -90,6 +101,57 @@ func main() {
 				// Not recommended because the janitor was not made to be a long-running software.
 				// It was not optimised in this way, despite it should work fine.
 			},
+			&cli.BoolFlag{
+				Name:     "dry-run",
+				Sources:  cli.EnvVars("DRY_RUN"),
+				Category: "report",
+				Usage:    "If set, only report what would be janitored and why, without deleting anything.",
+			},
+			&cli.StringFlag{
+				Name:     "report",
+				Sources:  cli.EnvVars("REPORT"),
+				Category: "report",
+				Usage:    "If set, write a JSON report of the run to this file, or to stdout if \"-\".",
+			},
+			&cli.BoolFlag{
+				Name:     "failed",
+				Sources:  cli.EnvVars("FAILED"),
+				Category: "passes",
+				Usage:    "Also delete instances stuck in a failed state.",
+			},
+			&cli.BoolFlag{
+				Name:     "pools",
+				Sources:  cli.EnvVars("POOLS"),
+				Category: "passes",
+				Usage: "Also delete the pooled instances of expired challenges. " +
+					"It drains their pools by resetting their min, max, schedule, variants and autoscale.",
+			},
+			&cli.BoolFlag{
+				Name:     "challenges",
+				Sources:  cli.EnvVars("CHALLENGES"),
+				Category: "passes",
+				Usage:    "Also delete expired challenges whose instances are all gone.",
+			},
+			&cli.IntFlag{
+				Name:     "parallelism",
+				Sources:  cli.EnvVars("PARALLELISM"),
+				Category: "resiliency",
+				Value:    10,
+				Usage:    "The maximum number of concurrent deletions.",
+				Action: func(_ context.Context, _ *cli.Command, i int) error {
+					if i < 1 {
+						return errors.New("parallelism must be at least 1")
+					}
+					return nil
+				},
+			},
+			&cli.BoolFlag{
+				Name:     "stream",
+				Sources:  cli.EnvVars("STREAM"),
+				Category: "resiliency",
+				Usage: "If set, only list the challenges then retrieve and janitor them one at a time, " +
+					"such that the whole list is never held in memory. Recommended on big events.",
+			},
 			&cli.IntFlag{
 				Name:     "max-requests",
 				Category: "resiliency",
@@ -118,7 +180,7 @@ func main() {
 					"breaker becomes half-open.",
 				Value: must(time.ParseDuration("10s")),
 			},
-		},
+		}, authFlags()...),
 		Action: run,
 		Authors: []any{
 			mail.Address{
@@ -155,12 +217,22 @@ func run(ctx context.Context, cmd *cli.Command) error {
 		err = multierr.Append(err, otelShutdown(ctx))
 	}()
 
+	if err := checkAuth(cmd); err != nil {
+		return err
+	}
+	creds, err := transportCredentials(cmd)
+	if err != nil {
+		return err
+	}
 	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
 		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 		grpc.WithUnaryInterceptor(interceptors.UnaryClientWithCaller(Tracer)),
 		grpc.WithStreamInterceptor(interceptors.StreamClientWithCaller(Tracer)),
 	}
+	if rpcCreds := perRPCCredentials(cmd); rpcCreds != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(rpcCreds))
+	}
 
 	logger := Log()
 
@@ -220,12 +292,21 @@ func run(ctx context.Context, cmd *cli.Command) error {
 		}
 	}(cli)
 
+	options := &Options{
+		DryRun:      cmd.Bool("dry-run"),
+		Report:      cmd.String("report"),
+		Failed:      cmd.Bool("failed"),
+		Pools:       cmd.Bool("pools"),
+		Challenges:  cmd.Bool("challenges"),
+		Parallelism: cmd.Int("parallelism"),
+		Stream:      cmd.Bool("stream"),
+	}
 	if cmd.IsSet("ticker") {
-		if err := janitorWithTicker(ctx, cli, cmd.Duration("ticker")); err != nil {
+		if err := janitorWithTicker(ctx, cli, cmd.Duration("ticker"), options); err != nil {
 			return err
 		}
 	} else {
-		if err := janitor(ctx, cli); err != nil {
+		if err := janitor(ctx, cli, options); err != nil {
 			return err
 		}
 		stop()
@@ -241,7 +322,7 @@ func run(ctx context.Context, cmd *cli.Command) error {
 	return nil
 }
 
-func janitorWithTicker(ctx context.Context, cli *grpc.ClientConn, d time.Duration) error {
+func janitorWithTicker(ctx context.Context, cli *grpc.ClientConn, d time.Duration, opts *Options) error {
 	logger := Log()
 	ticker := time.NewTicker(d)
 	wg := sync.WaitGroup{}
@@ -254,7 +335,7 @@ func janitorWithTicker(ctx context.Context, cli *grpc.ClientConn, d time.Duratio
 			go func(ctx context.Context, cli *grpc.ClientConn) {
 				defer wg.Done()
 
-				if err := janitor(ctx, cli); err != nil {
+				if err := janitor(ctx, cli, opts); err != nil {
 					logger.Error(ctx, "janitoring did not succeed", zap.Error(err))
 				}
 			}(ctx, cli)
@@ -268,36 +349,38 @@ func janitorWithTicker(ctx context.Context, cli *grpc.ClientConn, d time.Duratio
 	return nil
 }
 
-func janitor(ctx context.Context, cli *grpc.ClientConn) error {
+func janitor(ctx context.Context, cli *grpc.ClientConn, opts *Options) error {
 	logger := Log()
-	logger.Info(ctx, "starting janitoring")
+	logger.Info(ctx, "starting janitoring",
+		zap.Bool("dry_run", opts.DryRun),
+		zap.Bool("stream", opts.Stream),
+	)
 
-	store := challenge.NewChallengeStoreClient(cli)
-	manager := instance.NewInstanceManagerClient(cli)
+	j := &runner{
+		store:   challenge.NewChallengeStoreClient(cli),
+		manager: instance.NewInstanceManagerClient(cli),
+		report:  NewReport(opts.DryRun),
+		opts:    opts,
+		sem:     make(chan struct{}, opts.Parallelism),
+	}
 
 	span := trace.SpanFromContext(ctx)
 	span.AddEvent("querying challenges")
 
 	challStream, err := Execute(gcb, func() (grpc.ServerStreamingClient[challenge.Challenge], error) {
-		return store.QueryChallenge(ctx, nil)
+		return j.store.QueryChallenge(ctx, nil)
 	})
 	if err != nil {
-		if errors.Is(err, gobreaker.ErrOpenState) {
-			logger.Error(ctx, "circuit breaker is currently open",
-				zap.String("service", "chall-manager"),
-				zap.String("state", "open"),
-			)
-			return nil
-		}
-		if errors.Is(err, gobreaker.ErrTooManyRequests) {
-			logger.Error(ctx, "circuit breaker is half open yet had too many requests",
-				zap.String("service", "chall-manager"),
-				zap.String("state", "half-open"),
-			)
+		if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+			handleErr(ctx, err)
 			return nil
 		}
 		return err
 	}
+
+	// In streaming mode, only keep the challenges IDs then retrieve them one
+	// at a time, such that the whole list is never held in memory.
+	ids := []string{}
 	for {
 		chall, err := challStream.Recv()
 		if err != nil {
@@ -306,82 +389,639 @@ func janitor(ctx context.Context, cli *grpc.ClientConn) error {
 			}
 			return err
 		}
-		ctx := WithChallengeID(ctx, chall.Id)
+		if opts.Stream {
+			ids = append(ids, chall.Id)
+			continue
+		}
+		j.challenge(ctx, chall)
+	}
+	for _, id := range ids {
+		ctx := WithChallengeID(ctx, id)
+
+		chall, err := Execute(gcb, func() (*challenge.Challenge, error) {
+			return j.store.RetrieveChallenge(ctx, &challenge.RetrieveChallengeRequest{
+				Id: id,
+			})
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			handleErr(ctx, err)
+			continue
+		}
+		j.challenge(ctx, chall)
+	}
+
+	if err := j.report.Write(opts.Report); err != nil {
+		return err
+	}
+	logger.Info(ctx, "completed janitoring",
+		zap.Int("actions", len(j.report.Actions)),
+	)
+
+	return nil
+}
+
+// runner holds the state of a janitor run.
+type runner struct {
+	store   challenge.ChallengeStoreClient
+	manager instance.InstanceManagerClient
+	report  *Report
+	opts    *Options
+
+	// sem bounds the number of concurrent deletions
+	sem chan struct{}
+}
+
+// challenge janitors a challenge and its instances.
+func (j *runner) challenge(ctx context.Context, chall *challenge.Challenge) {
+	ctx = WithChallengeID(ctx, chall.Id)
+	now := time.Now()
+	challExpired := chall.Until != nil && now.After(chall.Until.AsTime())
+
+	// Delete expired challenges once all their instances are gone
+	if j.opts.Challenges && challExpired && len(chall.Instances) == 0 {
+		j.report.Run(ctx, &Action{
+			Kind:        KindDeleteChallenge,
+			ChallengeID: chall.Id,
+			Reason:      ReasonChallengeExpired,
+			Until:       timeOf(chall.Until),
+		}, func() error {
+			_, err := Execute(gcb, func() (*emptypb.Empty, error) {
+				return j.store.DeleteChallenge(ctx, &challenge.DeleteChallengeRequest{
+					Id: chall.Id,
+				})
+			})
+			return err
+		})
+		return
+	}
+
+	// Drain the pools of expired challenges, as they can't be claimed anymore
+	if j.opts.Pools && challExpired && hasPool(chall) {
+		j.report.Run(ctx, &Action{
+			Kind:        KindDrainPool,
+			ChallengeID: chall.Id,
+			Reason:      ReasonChallengeExpired,
+			Until:       timeOf(chall.Until),
+		}, func() error {
+			_, err := Execute(gcb, func() (*challenge.Challenge, error) {
+				return j.store.UpdateChallenge(ctx, &challenge.UpdateChallengeRequest{
+					Id: chall.Id,
+					UpdateMask: &fieldmaskpb.FieldMask{
+						Paths: []string{"min", "max", "schedule", "variants", "autoscale"},
+					},
+				})
+			})
+			return err
+		})
+	}
 
-		// Don't janitor if the challenge has no dates configured
-		if chall.Timeout == nil && chall.Until == nil {
-			logger.Debug(ctx, "skipping challenge with no dates configured")
+	// Janitor outdated instances, and failed ones if asked to
+	wg := &sync.WaitGroup{}
+	for _, ist := range chall.Instances {
+		reason := instanceReason(chall, ist, j.opts.Failed, now)
+		if reason == "" {
 			continue
 		}
 
-		// Janitor outdated instances
-		wg := &sync.WaitGroup{}
-		for _, ist := range chall.Instances {
-			if time.Now().After(ist.Until.AsTime()) {
-				ctx := WithSourceID(ctx, ist.SourceId)
-				logger.Info(ctx, "janitoring instance")
-
-				wg.Go(func() {
-					if _, err := Execute(gcb, func() (*emptypb.Empty, error) {
-						return manager.DeleteInstance(ctx, &instance.DeleteInstanceRequest{
-							ChallengeId: ist.ChallengeId,
-							SourceId:    ist.SourceId,
-						})
-					}); err != nil {
-						if errors.Is(err, gobreaker.ErrOpenState) {
-							logger.Error(ctx, "circuit breaker is currently open",
-								zap.String("service", "chall-manager"),
-								zap.String("state", "open"),
-							)
-							return
-						}
-						if errors.Is(err, gobreaker.ErrTooManyRequests) {
-							logger.Error(ctx, "circuit breaker is half open yet had too many requests",
-								zap.String("service", "chall-manager"),
-								zap.String("state", "half-open"),
-							)
-							return
-						}
-
-						st, ok := status.FromError(err)
-						if !ok {
-							logger.Error(ctx, "unexpected error",
-								zap.Error(err),
-							)
-							return
-						}
-						switch st.Code() {
-						case codes.NotFound:
-							// Might not exist anymore since the query, drop the error.
-							//
-							// Could be easily explained by a concurrent janitor arriving late
-							// or not in sync (it should not even run in parallel, but it will
-							// eventually happen) that concurrently destroys the instance,
-							// or has been destroyed by a downstream service.
-							return
-
-						case codes.Internal:
-							// An internal error happened, it is not our concern so drop it.
-							return
-
-						default:
-							logger.Error(ctx, "unexpected status error",
-								zap.Error(err),
-								zap.String("code", st.Code().String()),
-							)
-						}
-					}
+		ctx := WithSourceID(ctx, ist.SourceId)
+		j.sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-j.sem }()
+
+			j.report.Run(ctx, &Action{
+				Kind:        KindDeleteInstance,
+				ChallengeID: ist.ChallengeId,
+				SourceID:    ist.SourceId,
+				Reason:      reason,
+				Until:       timeOf(ist.Until),
+			}, func() error {
+				_, err := Execute(gcb, func() (*emptypb.Empty, error) {
+					return j.manager.DeleteInstance(ctx, &instance.DeleteInstanceRequest{
+						ChallengeId: ist.ChallengeId,
+						SourceId:    ist.SourceId,
+					})
 				})
+				return err
+			})
+		})
+	}
+	wg.Wait()
+}
+
+// instanceReason returns why an instance of a challenge should be janitored
+// at a given time, or an empty string if it should not.
+func instanceReason(chall *challenge.Challenge, ist *instance.Instance, failed bool, now time.Time) string {
+	// Don't consider expiration if the challenge has no dates configured
+	dated := chall.Timeout != nil || chall.Until != nil || chall.MaxLifetime != nil
+
+	switch {
+	case failed && ist.Status == instance.InstanceStatus_failed:
+		return ReasonFailed
+
+	// Paused time is credited back on resume, so can't expire meanwhile
+	case chall.ExcludePausedTime && ist.Status == instance.InstanceStatus_paused:
+		return ""
+
+	case dated && ist.Until != nil && now.After(ist.Until.AsTime()):
+		return ReasonExpired
+
+	default:
+		return ""
+	}
+}
+
+// hasPool returns whether a challenge could have pooled instances.
+func hasPool(chall *challenge.Challenge) bool {
+	return chall.Min != 0 || chall.Max != 0 || len(chall.Schedule) != 0 || len(chall.Variants) != 0
+}
+
+func timeOf(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
+// handleErr logs the error of an API call. It returns whether this error
+// is a failure of the janitor.
+func handleErr(ctx context.Context, err error) bool {
+	logger := Log()
+
+	if errors.Is(err, gobreaker.ErrOpenState) {
+		logger.Error(ctx, "circuit breaker is currently open",
+			zap.String("service", "chall-manager"),
+			zap.String("state", "open"),
+		)
+		return true
+	}
+	if errors.Is(err, gobreaker.ErrTooManyRequests) {
+		logger.Error(ctx, "circuit breaker is half open yet had too many requests",
+			zap.String("service", "chall-manager"),
+			zap.String("state", "half-open"),
+		)
+		return true
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		logger.Error(ctx, "unexpected error",
+			zap.Error(err),
+		)
+		return true
+	}
+	switch st.Code() {
+	case codes.NotFound:
+		// Might not exist anymore since the query, drop the error.
+		//
+		// Could be easily explained by a concurrent janitor arriving late
+		// or not in sync (it should not even run in parallel, but it will
+		// eventually happen) that concurrently destroys the instance,
+		// or has been destroyed by a downstream service.
+		return false
+
+	case codes.Internal:
+		// An internal error happened, it is not our concern so drop it.
+		return false
+
+	default:
+		logger.Error(ctx, "unexpected status error",
+			zap.Error(err),
+			zap.String("code", st.Code().String()),
+		)
+		return true
+	}
+}
+
+// region report
+
+// Options defines the behavior of a janitor run.
+type Options struct {
+	// DryRun reports the actions without performing them.
+	DryRun bool
+
+	// Report is the file to write the JSON report to, "-" for stdout.
+	// If empty, no report is written.
+	Report string
+
+	// Failed also deletes the instances in a failed state.
+	Failed bool
+
+	// Pools also drains the pools of expired challenges.
+	Pools bool
+
+	// Challenges also deletes the expired challenges with no instance left.
+	Challenges bool
+
+	// Parallelism is the maximum number of concurrent deletions.
+	Parallelism int
+
+	// Stream retrieves and janitors challenges one at a time rather than
+	// all at once.
+	Stream bool
+}
+
+const (
+	KindDeleteInstance  = "delete-instance"
+	KindDrainPool       = "drain-pool"
+	KindDeleteChallenge = "delete-challenge"
+
+	ReasonExpired          = "instance expired"
+	ReasonFailed           = "instance failed"
+	ReasonChallengeExpired = "challenge expired"
+)
+
+// Action is something the janitor did, or would have done in dry-run mode.
+type Action struct {
+	Kind        string     `json:"kind"`
+	ChallengeID string     `json:"challenge_id"`
+	SourceID    string     `json:"source_id,omitempty"`
+	Reason      string     `json:"reason"`
+	Until       *time.Time `json:"until,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Report lists the actions of a janitor run.
+type Report struct {
+	DryRun  bool      `json:"dry_run"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Actions []*Action `json:"actions"`
+
+	mx sync.Mutex
+}
+
+func NewReport(dryRun bool) *Report {
+	return &Report{
+		DryRun:  dryRun,
+		Start:   time.Now(),
+		Actions: []*Action{},
+	}
+}
+
+// Run performs the action, unless in dry-run mode, then records it.
+func (r *Report) Run(ctx context.Context, act *Action, f func() error) {
+	logger := Log()
+	fields := []zap.Field{
+		zap.String("kind", act.Kind),
+		zap.String("reason", act.Reason),
+	}
+
+	if r.DryRun {
+		logger.Info(ctx, "would janitor", fields...)
+	} else {
+		logger.Info(ctx, "janitoring", fields...)
+		if err := f(); err != nil {
+			if !handleErr(ctx, err) {
+				return // nothing left to do
 			}
+			act.Error = err.Error()
+		}
+	}
+
+	r.mx.Lock()
+	r.Actions = append(r.Actions, act)
+	r.mx.Unlock()
+}
+
+// Write writes the report as JSON to the given file, or to stdout if "-".
+// In dry-run mode without file, a human-readable summary is printed instead.
+func (r *Report) Write(path string) error {
+	r.End = time.Now()
+
+	if path == "" {
+		if !r.DryRun {
+			return nil
 		}
-		wg.Wait()
+		return r.summary(os.Stdout)
+	}
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
 	}
+	b = append(b, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
 
-	logger.Info(ctx, "completed janitoring")
+// summary writes the actions of the report as a human-readable table.
+func (r *Report) summary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ACTION\tCHALLENGE\tSOURCE\tREASON\tUNTIL")
+	for _, act := range r.Actions {
+		until := ""
+		if act.Until != nil {
+			until = act.Until.Format(time.RFC3339)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", act.Kind, act.ChallengeID, act.SourceID, act.Reason, until)
+	}
+	return tw.Flush()
+}
 
+// region auth
+
+// authFlags returns the flags to reach chall-manager securely.
+// Chall-Manager has no TLS nor authentication on its own: those are expected
+// to be handled by a proxy or service mesh in front of it.
+func authFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:     "tls",
+			Sources:  cli.EnvVars("TLS"),
+			Category: "tls",
+			Usage: "Use TLS to reach chall-manager through the proxy in front of it, verified against the system CAs " +
+				"unless --tls.ca is set. Implied by other tls flags.",
+		},
+		&cli.StringFlag{
+			Name:     "tls.ca",
+			Sources:  cli.EnvVars("TLS_CA"),
+			Category: "tls",
+			Usage:    "The PEM-encoded CA file to verify chall-manager certificate with.",
+		},
+		&cli.StringFlag{
+			Name:     "tls.cert",
+			Sources:  cli.EnvVars("TLS_CERT"),
+			Category: "tls",
+			Usage:    "The PEM-encoded client certificate file, for mTLS. Requires --tls.key.",
+		},
+		&cli.StringFlag{
+			Name:     "tls.key",
+			Sources:  cli.EnvVars("TLS_KEY"),
+			Category: "tls",
+			Usage:    "The PEM-encoded client private key file, for mTLS. Requires --tls.cert.",
+		},
+		&cli.StringFlag{
+			Name:     "tls.server-name",
+			Sources:  cli.EnvVars("TLS_SERVER_NAME"),
+			Category: "tls",
+			Usage:    "The server name to verify chall-manager certificate against, if it differs from the URL host.",
+		},
+		&cli.StringFlag{
+			Name:     "auth.token",
+			Sources:  cli.EnvVars("AUTH_TOKEN"),
+			Category: "auth",
+			Usage:    "The bearer token to authenticate with. Requires TLS.",
+		},
+		&cli.StringFlag{
+			Name:     "auth.token-file",
+			Sources:  cli.EnvVars("AUTH_TOKEN_FILE"),
+			Category: "auth",
+			Usage: "The file to read the bearer token to authenticate with from, on every request " +
+				"such that it can be rotated (e.g. a Kubernetes projected service account token). Requires TLS.",
+		},
+		&cli.StringFlag{
+			Name:     "oidc.issuer",
+			Sources:  cli.EnvVars("OIDC_ISSUER"),
+			Category: "auth",
+			Usage: "The OIDC issuer URL to get a bearer token from, through the client credentials grant. " +
+				"Requires TLS.",
+		},
+		&cli.StringFlag{
+			Name:     "oidc.client-id",
+			Sources:  cli.EnvVars("OIDC_CLIENT_ID"),
+			Category: "auth",
+			Usage:    "The OIDC client ID.",
+		},
+		&cli.StringFlag{
+			Name:     "oidc.client-secret",
+			Sources:  cli.EnvVars("OIDC_CLIENT_SECRET"),
+			Category: "auth",
+			Usage:    "The OIDC client secret.",
+		},
+		&cli.StringSliceFlag{
+			Name:     "oidc.scopes",
+			Sources:  cli.EnvVars("OIDC_SCOPES"),
+			Category: "auth",
+			Usage:    "The OIDC scopes to request.",
+		},
+	}
+}
+
+// checkAuth validates the TLS and authentication flags are consistent.
+func checkAuth(cmd *cli.Command) error {
+	if cmd.IsSet("tls.cert") != cmd.IsSet("tls.key") {
+		return errors.New("tls.cert and tls.key must be set together")
+	}
+	auths := 0
+	for _, name := range []string{"auth.token", "auth.token-file", "oidc.issuer"} {
+		if cmd.IsSet(name) {
+			auths++
+		}
+	}
+	if auths > 1 {
+		return errors.New("auth.token, auth.token-file and oidc.issuer are mutually exclusive")
+	}
+	if cmd.IsSet("oidc.issuer") && !cmd.IsSet("oidc.client-id") {
+		return errors.New("oidc.client-id is required with oidc.issuer")
+	}
+	if auths == 1 && !useTLS(cmd) {
+		return errors.New("authentication requires TLS, not to leak credentials")
+	}
 	return nil
 }
 
+// useTLS returns whether to reach chall-manager over TLS.
+func useTLS(cmd *cli.Command) bool {
+	return cmd.Bool("tls") || cmd.IsSet("tls.ca") || cmd.IsSet("tls.cert") || cmd.IsSet("tls.server-name")
+}
+
+// transportCredentials returns the credentials to dial chall-manager with,
+// i.e. TLS or mTLS if configured, else plaintext.
+func transportCredentials(cmd *cli.Command) (credentials.TransportCredentials, error) {
+	if !useTLS(cmd) {
+		return insecure.NewCredentials(), nil
+	}
+
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cmd.String("tls.server-name"),
+	}
+	if ca := cmd.String("tls.ca"); ca != "" {
+		b, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in %s", ca)
+		}
+		conf.RootCAs = pool
+	}
+	if cert := cmd.String("tls.cert"); cert != "" {
+		kp, err := tls.LoadX509KeyPair(cert, cmd.String("tls.key"))
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{kp}
+	}
+	return credentials.NewTLS(conf), nil
+}
+
+// perRPCCredentials returns the bearer token credentials, or nil if no
+// authentication is configured.
+func perRPCCredentials(cmd *cli.Command) credentials.PerRPCCredentials {
+	switch {
+	case cmd.IsSet("auth.token"):
+		token := cmd.String("auth.token")
+		return &bearer{
+			token: func(context.Context) (string, error) {
+				return token, nil
+			},
+		}
+
+	case cmd.IsSet("auth.token-file"):
+		path := cmd.String("auth.token-file")
+		return &bearer{
+			token: func(context.Context) (string, error) {
+				b, err := os.ReadFile(path)
+				if err != nil {
+					return "", err
+				}
+				return strings.TrimSpace(string(b)), nil
+			},
+		}
+
+	case cmd.IsSet("oidc.issuer"):
+		src := &oidcSource{
+			issuer:       strings.TrimSuffix(cmd.String("oidc.issuer"), "/"),
+			clientID:     cmd.String("oidc.client-id"),
+			clientSecret: cmd.String("oidc.client-secret"),
+			scopes:       cmd.StringSlice("oidc.scopes"),
+			client: &http.Client{
+				Transport: otelhttp.NewTransport(http.DefaultTransport),
+				Timeout:   10 * time.Second,
+			},
+		}
+		return &bearer{
+			token: src.Token,
+		}
+	}
+	return nil
+}
+
+// bearer authenticates each RPC with a bearer token.
+type bearer struct {
+	token func(ctx context.Context) (string, error)
+}
+
+var _ credentials.PerRPCCredentials = (*bearer)(nil)
+
+func (b *bearer) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := b.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"authorization": "Bearer " + token,
+	}, nil
+}
+
+func (b *bearer) RequireTransportSecurity() bool {
+	return true
+}
+
+// oidcSource gets access tokens from an OIDC provider through the client
+// credentials grant, and caches them until they are about to expire.
+type oidcSource struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+
+	mx       sync.Mutex
+	endpoint string
+	token    string
+	expiry   time.Time
+}
+
+func (src *oidcSource) Token(ctx context.Context) (string, error) {
+	src.mx.Lock()
+	defer src.mx.Unlock()
+
+	if src.token != "" && time.Now().Before(src.expiry) {
+		return src.token, nil
+	}
+
+	// Discover the token endpoint once
+	if src.endpoint == "" {
+		conf := struct {
+			TokenEndpoint string `json:"token_endpoint"`
+		}{}
+		if err := src.do(ctx, http.MethodGet, src.issuer+"/.well-known/openid-configuration", nil, &conf); err != nil {
+			return "", err
+		}
+		if conf.TokenEndpoint == "" {
+			return "", fmt.Errorf("OIDC issuer %s has no token endpoint", src.issuer)
+		}
+		src.endpoint = conf.TokenEndpoint
+	}
+
+	form := url.Values{
+		"grant_type": []string{"client_credentials"},
+	}
+	if len(src.scopes) != 0 {
+		form.Set("scope", strings.Join(src.scopes, " "))
+	}
+	tok := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := src.do(ctx, http.MethodPost, src.endpoint, form, &tok); err != nil {
+		return "", err
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("OIDC token response has no access token")
+	}
+
+	// Renew a bit before the expiration, not to use an expired token in flight.
+	// If the provider does not tell, don't cache it.
+	lifetime := time.Duration(tok.ExpiresIn) * time.Second
+	if lifetime > time.Minute {
+		lifetime -= 30 * time.Second
+	}
+	src.token = tok.AccessToken
+	src.expiry = time.Now().Add(lifetime)
+	return src.token, nil
+}
+
+// do sends a request to the OIDC provider, as a form if any, and decodes
+// its JSON response in dst.
+func (src *oidcSource) do(ctx context.Context, method, u string, form url.Values, dst any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(url.QueryEscape(src.clientID), url.QueryEscape(src.clientSecret))
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := src.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("OIDC request to %s returned %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(dst)
+}
+
 // region circuit breaker
 
 type GlobalCircuitBreaker struct {
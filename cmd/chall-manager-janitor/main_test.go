@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/ctfer-io/chall-manager/api/v1/challenge"
+	"github.com/ctfer-io/chall-manager/api/v1/instance"
+)
+
+func Test_U_InstanceReason(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	past := timestamppb.New(now.Add(-time.Minute))
+	future := timestamppb.New(now.Add(time.Minute))
+	timeout := durationpb.New(time.Hour)
+
+	var tests = map[string]struct {
+		Challenge *challenge.Challenge
+		Instance  *instance.Instance
+		Failed    bool
+		Expected  string
+	}{
+		"expired": {
+			Challenge: &challenge.Challenge{Timeout: timeout},
+			Instance:  &instance.Instance{Until: past},
+			Expected:  ReasonExpired,
+		},
+		"running": {
+			Challenge: &challenge.Challenge{Timeout: timeout},
+			Instance:  &instance.Instance{Until: future},
+			Expected:  "",
+		},
+		"undated-challenge": {
+			Challenge: &challenge.Challenge{},
+			Instance:  &instance.Instance{Until: past},
+			Expected:  "",
+		},
+		"no-until": {
+			Challenge: &challenge.Challenge{Timeout: timeout},
+			Instance:  &instance.Instance{},
+			Expected:  "",
+		},
+		"failed": {
+			Challenge: &challenge.Challenge{},
+			Instance:  &instance.Instance{Status: instance.InstanceStatus_failed},
+			Failed:    true,
+			Expected:  ReasonFailed,
+		},
+		"failed-not-asked": {
+			Challenge: &challenge.Challenge{},
+			Instance:  &instance.Instance{Status: instance.InstanceStatus_failed},
+			Expected:  "",
+		},
+		"failed-expired": {
+			Challenge: &challenge.Challenge{Timeout: timeout},
+			Instance:  &instance.Instance{Status: instance.InstanceStatus_failed, Until: past},
+			Failed:    true,
+			Expected:  ReasonFailed,
+		},
+		"paused-excluded": {
+			Challenge: &challenge.Challenge{Timeout: timeout, ExcludePausedTime: true},
+			Instance:  &instance.Instance{Status: instance.InstanceStatus_paused, Until: past},
+			Expected:  "",
+		},
+		"paused-included": {
+			Challenge: &challenge.Challenge{Timeout: timeout},
+			Instance:  &instance.Instance{Status: instance.InstanceStatus_paused, Until: past},
+			Expected:  ReasonExpired,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.Expected, instanceReason(tt.Challenge, tt.Instance, tt.Failed, now))
+		})
+	}
+}
+
+func Test_U_HasPool(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Challenge *challenge.Challenge
+		Expected  bool
+	}{
+		"none": {
+			Challenge: &challenge.Challenge{},
+			Expected:  false,
+		},
+		"min": {
+			Challenge: &challenge.Challenge{Min: 1},
+			Expected:  true,
+		},
+		"max": {
+			Challenge: &challenge.Challenge{Max: 3},
+			Expected:  true,
+		},
+		"schedule": {
+			Challenge: &challenge.Challenge{Schedule: []*challenge.PoolWindow{{}}},
+			Expected:  true,
+		},
+		"variants": {
+			Challenge: &challenge.Challenge{Variants: []*challenge.PoolVariant{{}}},
+			Expected:  true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.Expected, hasPool(tt.Challenge))
+		})
+	}
+}
+
+func Test_U_ReportSummary(t *testing.T) {
+	t.Parallel()
+
+	until := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	r := NewReport(true)
+	r.Actions = append(r.Actions, &Action{
+		Kind:        KindDeleteInstance,
+		ChallengeID: "chall",
+		SourceID:    "source",
+		Reason:      ReasonExpired,
+		Until:       &until,
+	}, &Action{
+		Kind:        KindDrainPool,
+		ChallengeID: "chall",
+		Reason:      ReasonChallengeExpired,
+	})
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, r.summary(buf))
+
+	expected := "" +
+		"ACTION           CHALLENGE  SOURCE  REASON             UNTIL\n" +
+		"delete-instance  chall      source  instance expired   2026-10-18T12:00:00Z\n" +
+		"drain-pool       chall              challenge expired  \n"
+	assert.Equal(t, expected, buf.String())
+}
+
+func Test_U_ReportWrite(t *testing.T) {
+	t.Parallel()
+
+	r := NewReport(false)
+	r.Actions = append(r.Actions, &Action{
+		Kind:        KindDeleteChallenge,
+		ChallengeID: "chall",
+		Reason:      ReasonChallengeExpired,
+		Error:       "some error",
+	})
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, r.Write(path))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	got := &Report{}
+	require.NoError(t, json.Unmarshal(b, got))
+	assert.False(t, got.DryRun)
+	assert.False(t, got.End.IsZero())
+	assert.Equal(t, r.Actions, got.Actions)
+}
+
+func Test_U_CheckAuth(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Args      []string
+		ExpectErr bool
+	}{
+		"none": {
+			Args:      []string{},
+			ExpectErr: false,
+		},
+		"tls": {
+			Args:      []string{"--tls"},
+			ExpectErr: false,
+		},
+		"mtls": {
+			Args:      []string{"--tls.cert", "cert.pem", "--tls.key", "key.pem"},
+			ExpectErr: false,
+		},
+		"cert-without-key": {
+			Args:      []string{"--tls.cert", "cert.pem"},
+			ExpectErr: true,
+		},
+		"key-without-cert": {
+			Args:      []string{"--tls", "--tls.key", "key.pem"},
+			ExpectErr: true,
+		},
+		"token": {
+			Args:      []string{"--tls", "--auth.token", "token"},
+			ExpectErr: false,
+		},
+		"token-file-implied-tls": {
+			Args:      []string{"--tls.ca", "ca.pem", "--auth.token-file", "token"},
+			ExpectErr: false,
+		},
+		"token-without-tls": {
+			Args:      []string{"--auth.token", "token"},
+			ExpectErr: true,
+		},
+		"token-and-token-file": {
+			Args:      []string{"--tls", "--auth.token", "token", "--auth.token-file", "token"},
+			ExpectErr: true,
+		},
+		"token-and-oidc": {
+			Args:      []string{"--tls", "--auth.token", "token", "--oidc.issuer", "https://issuer", "--oidc.client-id", "id"},
+			ExpectErr: true,
+		},
+		"oidc": {
+			Args:      []string{"--tls", "--oidc.issuer", "https://issuer", "--oidc.client-id", "id"},
+			ExpectErr: false,
+		},
+		"oidc-without-client-id": {
+			Args:      []string{"--tls", "--oidc.issuer", "https://issuer"},
+			ExpectErr: true,
+		},
+		"oidc-without-tls": {
+			Args:      []string{"--oidc.issuer", "https://issuer", "--oidc.client-id", "id"},
+			ExpectErr: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			withAuthFlags(t, tt.Args, func(cmd *cli.Command) {
+				err := checkAuth(cmd)
+				if tt.ExpectErr {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+				}
+			})
+		})
+	}
+}
+
+func Test_U_UseTLS(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Args     []string
+		Expected bool
+	}{
+		"none": {
+			Args:     []string{},
+			Expected: false,
+		},
+		"tls": {
+			Args:     []string{"--tls"},
+			Expected: true,
+		},
+		"ca": {
+			Args:     []string{"--tls.ca", "ca.pem"},
+			Expected: true,
+		},
+		"cert": {
+			Args:     []string{"--tls.cert", "cert.pem", "--tls.key", "key.pem"},
+			Expected: true,
+		},
+		"server-name": {
+			Args:     []string{"--tls.server-name", "chall-manager"},
+			Expected: true,
+		},
+		"token-only": {
+			Args:     []string{"--auth.token", "token"},
+			Expected: false,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			withAuthFlags(t, tt.Args, func(cmd *cli.Command) {
+				assert.Equal(t, tt.Expected, useTLS(cmd))
+			})
+		})
+	}
+}
+
+func Test_U_TransportCredentials(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cert, key := writeCert(t, dir)
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte{}, 0o600))
+
+	var tests = map[string]struct {
+		Args             []string
+		ExpectedProtocol string
+		ExpectErr        bool
+	}{
+		"plaintext": {
+			Args:             []string{},
+			ExpectedProtocol: "insecure",
+		},
+		"tls": {
+			Args:             []string{"--tls"},
+			ExpectedProtocol: "tls",
+		},
+		"ca": {
+			Args:             []string{"--tls.ca", cert},
+			ExpectedProtocol: "tls",
+		},
+		"mtls": {
+			Args:             []string{"--tls.ca", cert, "--tls.cert", cert, "--tls.key", key},
+			ExpectedProtocol: "tls",
+		},
+		"missing-ca": {
+			Args:      []string{"--tls.ca", filepath.Join(dir, "missing.pem")},
+			ExpectErr: true,
+		},
+		"empty-ca": {
+			Args:      []string{"--tls.ca", empty},
+			ExpectErr: true,
+		},
+		"mismatching-key": {
+			Args:      []string{"--tls.cert", cert, "--tls.key", cert},
+			ExpectErr: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			withAuthFlags(t, tt.Args, func(cmd *cli.Command) {
+				creds, err := transportCredentials(cmd)
+				if tt.ExpectErr {
+					assert.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.ExpectedProtocol, creds.Info().SecurityProtocol)
+			})
+		})
+	}
+}
+
+// withAuthFlags parses the args against the auth flags then calls f with the
+// resulting command.
+func withAuthFlags(t *testing.T, args []string, f func(cmd *cli.Command)) {
+	t.Helper()
+
+	called := false
+	cmd := &cli.Command{
+		Name:  "test",
+		Flags: authFlags(),
+		Action: func(_ context.Context, cmd *cli.Command) error {
+			called = true
+			f(cmd)
+			return nil
+		},
+	}
+	require.NoError(t, cmd.Run(t.Context(), append([]string{"test"}, args...)))
+	require.True(t, called)
+}
+
+// writeCert writes a self-signed certificate and its key as PEM files in dir,
+// and returns their paths.
+func writeCert(t *testing.T, dir string) (cert, key string) {
+	t.Helper()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "chall-manager"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &pk.PublicKey, pk)
+	require.NoError(t, err)
+	pkDer, err := x509.MarshalECPrivateKey(pk)
+	require.NoError(t, err)
+
+	cert = filepath.Join(dir, "cert.pem")
+	key = filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: pkDer}), 0o600))
+	return cert, key
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/mail"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"syscall"
+	"time"
 
+	"github.com/ctfer-io/chall-manager/api/v1/common"
+	"github.com/ctfer-io/chall-manager/api/v1/instance"
 	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/ctfer-io/chall-manager/pkg/leader"
 	"github.com/ctfer-io/chall-manager/server"
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v3"
@@ -109,6 +115,22 @@ func main() {
 					return nil
 				},
 			},
+			&cli.Int64Flag{
+				Name:        "capacity.budget",
+				Sources:     cli.EnvVars("CAPACITY_BUDGET"),
+				Category:    "capacity",
+				Destination: &global.Conf.Budget,
+				Usage: "Define the server-wide capacity budget, in units, shared by all pooled and claimed instances. " +
+					"An instance consumes its challenge weight (default to 1). Default to 0, i.e. unlimited.",
+			},
+			&cli.Int64Flag{
+				Name:        "capacity.source-quota",
+				Sources:     cli.EnvVars("CAPACITY_SOURCE_QUOTA"),
+				Category:    "capacity",
+				Destination: &global.Conf.Quota,
+				Usage: "Define the maximum number of instances a source can hold at once, across all challenges. " +
+					"Challenges can override it. Default to 0, i.e. unlimited.",
+			},
 			&cli.BoolFlag{
 				Name:        "oci.insecure",
 				Sources:     cli.EnvVars("OCI_INSECURE"),
@@ -130,6 +152,180 @@ func main() {
 				Destination: &global.Conf.OCI.Password,
 				Usage:       `Configure the OCI registry password to pull scenarios from.`,
 			},
+			&cli.DurationFlag{
+				Name:     "pool.interval",
+				Sources:  cli.EnvVars("POOL_INTERVAL"),
+				Category: "pool",
+				Value:    time.Minute,
+				Usage:    "Define the interval at which challenges pools are reconciled, e.g. to apply their schedule.",
+				Action: func(_ context.Context, _ *cli.Command, d time.Duration) error {
+					if d <= 0 {
+						return errors.New("pool.interval must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:        "pool.healthcheck-timeout",
+				Sources:     cli.EnvVars("POOL_HEALTHCHECK_TIMEOUT"),
+				Category:    "pool",
+				Value:       2 * time.Minute,
+				Destination: &global.Conf.Pool.HealthcheckTimeout,
+				Usage:       "Define how long a pooled instance has to pass its healthcheck once deployed, before being destroyed.",
+			},
+			&cli.DurationFlag{
+				Name:        "pool.pending-timeout",
+				Sources:     cli.EnvVars("POOL_PENDING_TIMEOUT"),
+				Category:    "pool",
+				Value:       15 * time.Minute,
+				Destination: &global.Conf.Pool.PendingTimeout,
+				Usage: "Define how long a newly elected leader waits for the spin-ups of the previous one to complete, " +
+					"before considering them interrupted.",
+			},
+			&cli.StringFlag{
+				Name:     "recovery.policy",
+				Sources:  cli.EnvVars("RECOVERY_POLICY"),
+				Category: "recovery",
+				Value:    iac.PolicyResume,
+				Action: func(_ context.Context, _ *cli.Command, policy string) error {
+					if policy != iac.PolicyResume && policy != iac.PolicyDestroy {
+						return fmt.Errorf("invalid recovery policy %s, should be %s or %s", policy, iac.PolicyResume, iac.PolicyDestroy)
+					}
+					return nil
+				},
+				Destination: &global.Conf.Recovery.Policy,
+				Usage: "Define what to do with instances interrupted during an update or destroy, once recovered on startup: " +
+					"resume them (re-apply the scenario) or destroy them.",
+			},
+			&cli.IntFlag{
+				Name:        "bulk.parallelism",
+				Sources:     cli.EnvVars("BULK_PARALLELISM"),
+				Category:    "bulk",
+				Value:       10,
+				Destination: &global.Conf.Bulk.Parallelism,
+				Usage:       "The maximum number of concurrent operations of a bulk request, e.g. the deletions of a challenge instances.",
+				Action: func(_ context.Context, _ *cli.Command, i int) error {
+					if i < 1 {
+						return errors.New("bulk.parallelism must be at least 1")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:        "readiness.timeout",
+				Sources:     cli.EnvVars("READINESS_TIMEOUT"),
+				Category:    "readiness",
+				Value:       time.Minute,
+				Destination: &global.Conf.Readiness.Timeout,
+				Usage: "Define how long a new instance has to pass its readiness probes, if any, before being returned. " +
+					"If they don't pass in time, it is returned as starting, until they do. " +
+					"If 0, it is returned as starting right away.",
+			},
+			&cli.DurationFlag{
+				Name:        "readiness.interval",
+				Sources:     cli.EnvVars("READINESS_INTERVAL"),
+				Category:    "readiness",
+				Value:       10 * time.Second,
+				Destination: &global.Conf.Readiness.Interval,
+				Usage:       "Define the interval between two checks of the readiness probes of starting instances.",
+				Action: func(_ context.Context, _ *cli.Command, d time.Duration) error {
+					if d <= 0 {
+						return errors.New("readiness.interval must be positive")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:     "janitor.interval",
+				Sources:  cli.EnvVars("JANITOR_INTERVAL"),
+				Category: "janitor",
+				Usage: "If set, run the janitor within chall-manager at this interval, to delete expired instances. " +
+					"It then replaces the chall-manager-janitor. Default to 0, i.e. disabled.",
+			},
+			&cli.IntFlag{
+				Name:     "janitor.parallelism",
+				Sources:  cli.EnvVars("JANITOR_PARALLELISM"),
+				Category: "janitor",
+				Value:    10,
+				Usage:    "The maximum number of concurrent deletions of the embedded janitor.",
+				Action: func(_ context.Context, _ *cli.Command, i int) error {
+					if i < 1 {
+						return errors.New("janitor.parallelism must be at least 1")
+					}
+					return nil
+				},
+			},
+			&cli.StringSliceFlag{
+				Name:        "events.sinks",
+				Sources:     cli.EnvVars("EVENTS_SINKS"),
+				Category:    "events",
+				Destination: &global.Conf.Events.Sinks,
+				Usage: "Define the sinks to publish challenges and instances lifecycle CloudEvents to, as URLs: " +
+					"http(s)://host/path, nats://[user:pass@]host:port/subject or file:///path/to/events.ndjson. Default to none, i.e. disabled.",
+			},
+			&cli.StringFlag{
+				Name:        "events.source",
+				Sources:     cli.EnvVars("EVENTS_SOURCE"),
+				Category:    "events",
+				Value:       "/chall-manager",
+				Destination: &global.Conf.Events.Source,
+				Usage:       "Define the source of the published CloudEvents, e.g. to distinguish several chall-manager deployments.",
+			},
+			&cli.StringSliceFlag{
+				Name:        "notify.webhooks",
+				Sources:     cli.EnvVars("NOTIFY_WEBHOOKS"),
+				Category:    "notify",
+				Destination: &global.Conf.Notify.Webhooks,
+				Usage:       "Define the webhooks URLs to notify of instances expiration. Default to none, i.e. disabled.",
+				Action: func(_ context.Context, cmd *cli.Command, webhooks []string) error {
+					if len(webhooks) != 0 && cmd.String("notify.secret") == "" {
+						return errors.New("notify.secret must be set along notify.webhooks, such that webhooks can authenticate notifications")
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:        "notify.secret",
+				Sources:     cli.EnvVars("NOTIFY_SECRET"),
+				Category:    "notify",
+				Destination: &global.Conf.Notify.Secret,
+				Usage:       "Define the secret to sign notifications payloads with (HMAC-SHA256), such that webhooks can authenticate them. Required along notify.webhooks.",
+			},
+			&cli.DurationFlag{
+				Name:        "notify.before",
+				Sources:     cli.EnvVars("NOTIFY_BEFORE"),
+				Category:    "notify",
+				Value:       10 * time.Minute,
+				Destination: &global.Conf.Notify.Before,
+				Usage:       "Define how long before an instance expires its sources are warned. Set to 0 to only notify expirations.",
+			},
+			&cli.IntFlag{
+				Name:        "notify.max-attempts",
+				Sources:     cli.EnvVars("NOTIFY_MAX_ATTEMPTS"),
+				Category:    "notify",
+				Value:       5,
+				Destination: &global.Conf.Notify.MaxAttempts,
+				Usage:       "Define the number of attempts to deliver a notification to a webhook before dropping it.",
+				Action: func(_ context.Context, _ *cli.Command, i int) error {
+					if i < 1 {
+						return errors.New("notify.max-attempts must be at least 1")
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:     "notify.interval",
+				Sources:  cli.EnvVars("NOTIFY_INTERVAL"),
+				Category: "notify",
+				Value:    30 * time.Second,
+				Usage:    "Define the interval at which expiring instances are looked for, and notifications delivered.",
+				Action: func(_ context.Context, _ *cli.Command, d time.Duration) error {
+					if d <= 0 {
+						return errors.New("notify.interval must be positive")
+					}
+					return nil
+				},
+			},
 		},
 		Action: run,
 		Authors: []any{
@@ -190,6 +386,16 @@ func run(ctx context.Context, cmd *cli.Command) error {
 		return errors.Wrapf(err, "during mkdir of challenges directory %s", challDir)
 	}
 
+	// Set up the events stream
+	if err := common.SetupEvents(); err != nil {
+		return errors.Wrap(err, "setting up events sinks")
+	}
+	defer func() {
+		if err := common.CloseEvents(); err != nil {
+			logger.Error(ctx, "closing events sinks", zap.Error(err))
+		}
+	}()
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -202,6 +408,14 @@ func run(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 
+	// Launch pool, notification, janitor and readiness background jobs, on the leader only
+	go leader.Run(ctx, func(ctx context.Context) {
+		go instance.RunNotifier(ctx, cmd.Duration("notify.interval"))
+		go instance.RunJanitor(ctx, cmd.Duration("janitor.interval"), cmd.Int("janitor.parallelism"))
+		go instance.RunReadiness(ctx, global.Conf.Readiness.Interval)
+		instance.RunBackground(ctx, cmd.Duration("pool.interval"))
+	})
+
 	// Listen for the interrupt signal
 	<-ctx.Done()
 
@@ -1,5 +1,7 @@
 package global
 
+import "time"
+
 var (
 	Version = ""
 )
@@ -9,6 +11,8 @@ type Configuration struct {
 	Directory string
 	Cache     string
 	LogLevel  string
+	Budget    int64
+	Quota     int64
 
 	Etcd struct {
 		Endpoint string
@@ -16,6 +20,36 @@ type Configuration struct {
 		Password string //nolint:gosec //#gosec G117 -- FP, we don't marshal this object into JSON
 	}
 
+	Pool struct {
+		HealthcheckTimeout time.Duration
+		PendingTimeout     time.Duration
+	}
+
+	Recovery struct {
+		Policy string
+	}
+
+	Bulk struct {
+		Parallelism int
+	}
+
+	Readiness struct {
+		Timeout  time.Duration
+		Interval time.Duration
+	}
+
+	Events struct {
+		Sinks  []string
+		Source string
+	}
+
+	Notify struct {
+		Webhooks    []string
+		Secret      string //nolint:gosec //#gosec G117 -- FP, we don't marshal this object into JSON
+		Before      time.Duration
+		MaxAttempts int
+	}
+
 	OCI struct {
 		Insecure bool
 		Username string
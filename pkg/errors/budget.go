@@ -0,0 +1,53 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BudgetExhausted is returned when deploying an instance would exceed the
+// server-wide capacity budget.
+type BudgetExhausted struct {
+	ChallengeID string
+	Budget      int64
+	Used        int64
+	Weight      int64
+}
+
+var _ error = (*BudgetExhausted)(nil)
+
+func (err BudgetExhausted) Error() string {
+	return err.statusError().Error()
+}
+
+var _ meaningfulError = (*BudgetExhausted)(nil)
+
+func (err BudgetExhausted) statusError() error {
+	st, serr := status.New(codes.ResourceExhausted, "Capacity budget is exhausted.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: ReasonBudgetExhausted,
+			Domain: Domain,
+			Metadata: map[string]string{
+				"challenge_id": err.ChallengeID,
+				"budget":       fmt.Sprintf("%d", err.Budget),
+				"used":         fmt.Sprintf("%d", err.Used),
+				"weight":       fmt.Sprintf("%d", err.Weight),
+			},
+		},
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{
+				{
+					Subject:     "global",
+					Description: fmt.Sprintf("%d/%d units are used, an instance of this challenge requires %d.", err.Used, err.Budget, err.Weight),
+				},
+			},
+		},
+	)
+	if serr != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", serr)
+	}
+	return st.Err()
+}
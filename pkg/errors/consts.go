@@ -14,12 +14,26 @@ const (
 	ReasonChallengeNoRenewal     = "CHALLENGE_NO_RENEWAL"
 	ReasonChallengePoolerOOB     = "CHALLENGE_POOLER_OUT_OF_BOUNDS"
 	ReasonChallengeInvalidUM     = "CHALLENGE_INVALID_UPDATE_MASK"
+	ReasonChallengeSchedule      = "CHALLENGE_INVALID_SCHEDULE"
+	ReasonChallengeWeight        = "CHALLENGE_INVALID_WEIGHT"
+	ReasonChallengePoolMaxAge    = "CHALLENGE_INVALID_POOL_MAX_AGE"
+	ReasonChallengeVariants      = "CHALLENGE_INVALID_VARIANTS"
+	ReasonChallengeSourceQuota   = "CHALLENGE_INVALID_SOURCE_QUOTA"
+	ReasonChallengeSharing       = "CHALLENGE_INVALID_SHARING"
+	ReasonChallengeRenewal       = "CHALLENGE_INVALID_RENEWAL_POLICY"
 
 	// => Instance errors (business layer)
 
 	ReasonInstanceAlreadyExists = "INSTANCE_ALREADY_EXISTS"
 	ReasonInstanceNotFound      = "INSTANCE_NOT_FOUND"
 	ReasonInstanceExpired       = "INSTANCE_EXPIRED"
+	ReasonInstanceNotReady      = "INSTANCE_NOT_READY"
+	ReasonInstanceShared        = "INSTANCE_SHARED"
+	ReasonInstanceMaxRenewals   = "INSTANCE_MAX_RENEWALS"
+	ReasonInstanceMaxLifetime   = "INSTANCE_MAX_LIFETIME"
+	ReasonInstanceRenewWindow   = "INSTANCE_OUTSIDE_RENEW_WINDOW"
+	ReasonBudgetExhausted       = "BUDGET_EXHAUSTED"
+	ReasonQuotaExceeded         = "QUOTA_EXCEEDED"
 
 	// => OCI/Scenario errors
 
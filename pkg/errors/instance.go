@@ -65,3 +65,46 @@ func (err InstanceExist) statusError() error {
 	}
 	return st.Err()
 }
+
+// InstanceShared is returned when an operation would affect the other sources
+// an instance is shared with.
+type InstanceShared struct {
+	ChallengeID string
+	SourceID    string
+	Sources     int
+}
+
+var _ error = (*InstanceShared)(nil)
+
+func (err InstanceShared) Error() string {
+	return err.statusError().Error()
+}
+
+var _ meaningfulError = (*InstanceShared)(nil)
+
+func (err InstanceShared) statusError() error {
+	st, serr := status.New(codes.FailedPrecondition, "Instance is shared.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: ReasonInstanceShared,
+			Domain: Domain,
+			Metadata: map[string]string{
+				"challenge_id": err.ChallengeID,
+				"source_id":    err.SourceID,
+				"sources":      fmt.Sprintf("%d", err.Sources),
+			},
+		},
+		&errdetails.PreconditionFailure{
+			Violations: []*errdetails.PreconditionFailure_Violation{
+				{
+					Type:        "SHARING",
+					Subject:     Domain + "/Instance",
+					Description: "Instance is shared with other sources so can only be detached from by deleting it.",
+				},
+			},
+		},
+	)
+	if serr != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", serr)
+	}
+	return st.Err()
+}
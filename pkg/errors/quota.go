@@ -0,0 +1,56 @@
+package errors
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QuotaExceeded is returned when a source already holds as many instances
+// as its quota allows.
+type QuotaExceeded struct {
+	ChallengeID string
+	SourceID    string
+	Quota       int64
+	// Instances lists the challenges the source holds an instance of.
+	Instances []string
+}
+
+var _ error = (*QuotaExceeded)(nil)
+
+func (err QuotaExceeded) Error() string {
+	return err.statusError().Error()
+}
+
+var _ meaningfulError = (*QuotaExceeded)(nil)
+
+func (err QuotaExceeded) statusError() error {
+	violations := make([]*errdetails.QuotaFailure_Violation, 0, len(err.Instances))
+	for _, challengeID := range err.Instances {
+		violations = append(violations, &errdetails.QuotaFailure_Violation{
+			Subject:     fmt.Sprintf("source:%s/challenge:%s", err.SourceID, challengeID),
+			Description: fmt.Sprintf("Source holds an instance of challenge %s.", challengeID),
+		})
+	}
+	st, serr := status.New(codes.ResourceExhausted, "Instance quota is exceeded.").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: ReasonQuotaExceeded,
+			Domain: Domain,
+			Metadata: map[string]string{
+				"challenge_id": err.ChallengeID,
+				"source_id":    err.SourceID,
+				"quota":        fmt.Sprintf("%d", err.Quota),
+				"used":         fmt.Sprintf("%d", len(err.Instances)),
+			},
+		},
+		&errdetails.QuotaFailure{
+			Violations: violations,
+		},
+	)
+	if serr != nil {
+		return status.Errorf(codes.Internal, "failed to build error: %v", serr)
+	}
+	return st.Err()
+}
@@ -0,0 +1,172 @@
+// Package events publishes the lifecycle changes of challenges and instances
+// as CloudEvents (https://cloudevents.io), such that third parties (e.g. a
+// scoreboard, a chat bot or monitoring) can react to them rather than
+// polling the API.
+// Events are encoded in the CloudEvents v1.0 JSON structured mode, and
+// published to pluggable sinks.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/identity"
+)
+
+// SpecVersion is the CloudEvents specification version events comply with.
+const SpecVersion = "1.0"
+
+// Types of events.
+const (
+	TypeChallengeCreated    = "io.ctfer.chall-manager.challenge.created"
+	TypeChallengeUpdated    = "io.ctfer.chall-manager.challenge.updated"
+	TypeChallengeDeleted    = "io.ctfer.chall-manager.challenge.deleted"
+	TypeInstanceCreated     = "io.ctfer.chall-manager.instance.created"
+	TypeInstanceClaimed     = "io.ctfer.chall-manager.instance.claimed"
+	TypeInstanceRenewed     = "io.ctfer.chall-manager.instance.renewed"
+	TypeInstanceUpdated     = "io.ctfer.chall-manager.instance.updated"
+	TypeInstanceDeleted     = "io.ctfer.chall-manager.instance.deleted"
+	TypeInstanceJanitored   = "io.ctfer.chall-manager.instance.janitored"
+	TypeInstanceTransferred = "io.ctfer.chall-manager.instance.transferred"
+)
+
+// bufferSize is the number of events an Emitter holds before dropping new
+// ones, e.g. when a sink is slow.
+const bufferSize = 1024
+
+// Event is a CloudEvent, as encoded in JSON structured mode.
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype,omitempty"`
+	Data            any       `json:"data,omitempty"`
+}
+
+// ChallengeData is the data of challenge events.
+type ChallengeData struct {
+	ChallengeID string `json:"challenge_id"`
+}
+
+// InstanceData is the data of instance events.
+type InstanceData struct {
+	ChallengeID string `json:"challenge_id"`
+	SourceID    string `json:"source_id"`
+	// FromSourceID is the source the instance was transferred from to
+	// SourceID, only set on transferred events.
+	FromSourceID string     `json:"from_source_id,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Until        *time.Time `json:"until,omitempty"`
+}
+
+// Sink publishes events somewhere.
+type Sink interface {
+	Send(ctx context.Context, evt *Event) error
+	Close() error
+}
+
+// Emitter publishes events to sinks in the background, such that emitting
+// never blocks nor fails the operation it reports.
+type Emitter struct {
+	source string
+	sinks  []Sink
+
+	// mx guards evts against sends once closed, as events may still be
+	// emitted by background operations while shutting down
+	mx     sync.RWMutex
+	closed bool
+	evts   chan *Event
+	done   chan struct{}
+}
+
+// NewEmitter returns an Emitter publishing events from source to the sinks.
+// It must be closed once no longer used.
+func NewEmitter(source string, sinks ...Sink) *Emitter {
+	em := &Emitter{
+		source: source,
+		sinks:  sinks,
+		evts:   make(chan *Event, bufferSize),
+		done:   make(chan struct{}),
+	}
+	go em.run()
+	return em
+}
+
+// Emit publishes an event of a type about a subject, e.g. "<challenge_id>"
+// or "<challenge_id>/<source_id>".
+// The event is dropped if the emitter is overwhelmed or closed.
+func (em *Emitter) Emit(ctx context.Context, typ, subject string, data any) {
+	evt := &Event{
+		SpecVersion:     SpecVersion,
+		ID:              identity.New(),
+		Source:          em.source,
+		Type:            typ,
+		Subject:         subject,
+		Time:            time.Now(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+
+	em.mx.RLock()
+	defer em.mx.RUnlock()
+
+	if em.closed {
+		global.Log().Debug(ctx, "dropping event emitted once closed",
+			zap.String("type", typ),
+			zap.String("subject", subject),
+		)
+		return
+	}
+	select {
+	case em.evts <- evt:
+	default:
+		global.Log().Warn(ctx, "dropping event",
+			zap.String("type", typ),
+			zap.String("subject", subject),
+		)
+	}
+}
+
+// Close publishes the remaining events, then closes the sinks.
+// Events emitted afterwards are dropped.
+func (em *Emitter) Close() (err error) {
+	em.mx.Lock()
+	if em.closed {
+		em.mx.Unlock()
+		return nil
+	}
+	em.closed = true
+	close(em.evts)
+	em.mx.Unlock()
+
+	<-em.done
+	for _, sink := range em.sinks {
+		err = multierr.Append(err, sink.Close())
+	}
+	return
+}
+
+func (em *Emitter) run() {
+	defer close(em.done)
+
+	ctx := context.Background()
+	for evt := range em.evts {
+		for _, sink := range em.sinks {
+			if err := sink.Send(ctx, evt); err != nil {
+				global.Log().Warn(ctx, "publishing event",
+					zap.String("type", evt.Type),
+					zap.String("subject", evt.Subject),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package events_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	json "github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/pkg/events"
+)
+
+func Test_U_FileSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, err := events.NewSink("file://" + path)
+	require.NoError(t, err)
+
+	em := events.NewEmitter("/chall-manager", sink)
+	em.Emit(context.Background(), events.TypeChallengeCreated, "chall", &events.ChallengeData{
+		ChallengeID: "chall",
+	})
+	em.Emit(context.Background(), events.TypeInstanceCreated, "chall/source", &events.InstanceData{
+		ChallengeID: "chall",
+		SourceID:    "source",
+	})
+	require.NoError(t, em.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2)
+
+	evt := map[string]any{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &evt))
+	assert.Equal(t, events.SpecVersion, evt["specversion"])
+	assert.Equal(t, "/chall-manager", evt["source"])
+	assert.Equal(t, events.TypeInstanceCreated, evt["type"])
+	assert.Equal(t, "chall/source", evt["subject"])
+	assert.NotEmpty(t, evt["id"])
+	assert.Equal(t, map[string]any{
+		"challenge_id": "chall",
+		"source_id":    "source",
+	}, evt["data"])
+}
+
+func Test_U_TransferredEvent(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, err := events.NewSink("file://" + path)
+	require.NoError(t, err)
+
+	em := events.NewEmitter("/chall-manager", sink)
+	em.Emit(context.Background(), events.TypeInstanceTransferred, "chall/to", &events.InstanceData{
+		ChallengeID:  "chall",
+		SourceID:     "to",
+		FromSourceID: "from",
+		Status:       "ready",
+	})
+	require.NoError(t, em.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	evt := map[string]any{}
+	require.NoError(t, json.Unmarshal(b, &evt))
+	assert.Equal(t, events.TypeInstanceTransferred, evt["type"])
+	assert.Equal(t, "chall/to", evt["subject"])
+	assert.Equal(t, map[string]any{
+		"challenge_id":   "chall",
+		"source_id":      "to",
+		"from_source_id": "from",
+		"status":         "ready",
+	}, evt["data"])
+}
+
+func Test_U_EmitClosed(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	sink, err := events.NewSink("file://" + path)
+	require.NoError(t, err)
+
+	em := events.NewEmitter("/chall-manager", sink)
+	require.NoError(t, em.Close())
+	require.NoError(t, em.Close())
+
+	// Background operations may still emit while shutting down
+	assert.NotPanics(t, func() {
+		em.Emit(context.Background(), events.TypeChallengeDeleted, "chall", &events.ChallengeData{
+			ChallengeID: "chall",
+		})
+	})
+}
+
+func Test_U_HTTPSink(t *testing.T) {
+	t.Parallel()
+
+	recv := make(chan *events.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/cloudevents+json; charset=UTF-8", r.Header.Get("Content-Type"))
+
+		evt := &events.Event{}
+		b, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(b, evt)
+		recv <- evt
+	}))
+	defer srv.Close()
+
+	sink, err := events.NewSink(srv.URL)
+	require.NoError(t, err)
+	defer func() {
+		_ = sink.Close()
+	}()
+
+	require.NoError(t, sink.Send(context.Background(), &events.Event{
+		SpecVersion: events.SpecVersion,
+		ID:          "1",
+		Type:        events.TypeChallengeDeleted,
+	}))
+	evt := <-recv
+	assert.Equal(t, "1", evt.ID)
+	assert.Equal(t, events.TypeChallengeDeleted, evt.Type)
+}
+
+func Test_U_NATSSink(t *testing.T) {
+	t.Parallel()
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = lst.Close()
+	}()
+
+	// Fake NATS server accepting a single publication
+	type pub struct {
+		Connect string
+		Subject string
+		Payload string
+	}
+	recv := make(chan pub, 1)
+	go func() {
+		conn, err := lst.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		r := bufio.NewReader(conn)
+
+		_, _ = conn.Write([]byte("INFO {\"server_id\":\"test\",\"max_payload\":1048576,\"proto\":1}\r\n"))
+		connect, _ := r.ReadString('\n')
+		ping, _ := r.ReadString('\n')
+		if ping != "PING\r\n" {
+			return
+		}
+		_, _ = conn.Write([]byte("PONG\r\n"))
+
+		header, _ := r.ReadString('\n')
+		payload, _ := r.ReadString('\n')
+
+		// Acknowledge the flush of the publication
+		if ping, _ := r.ReadString('\n'); ping == "PING\r\n" {
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		}
+		recv <- pub{
+			Connect: connect,
+			Subject: strings.Fields(header)[1],
+			Payload: strings.TrimRight(payload, "\r\n"),
+		}
+	}()
+
+	sink, err := events.NewSink("nats://token@" + lst.Addr().String() + "/ctf.events")
+	require.NoError(t, err)
+	defer func() {
+		_ = sink.Close()
+	}()
+
+	require.NoError(t, sink.Send(context.Background(), &events.Event{
+		SpecVersion: events.SpecVersion,
+		ID:          "1",
+		Type:        events.TypeInstanceJanitored,
+	}))
+	p := <-recv
+	assert.Contains(t, p.Connect, `"auth_token":"token"`)
+	assert.Equal(t, "ctf.events", p.Subject)
+
+	evt := &events.Event{}
+	require.NoError(t, json.Unmarshal([]byte(p.Payload), evt))
+	assert.Equal(t, events.TypeInstanceJanitored, evt.Type)
+}
+
+func Test_U_NewSink(t *testing.T) {
+	t.Parallel()
+
+	_, err := events.NewSink("kafka://localhost:9092")
+	assert.Error(t, err)
+}
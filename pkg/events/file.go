@@ -0,0 +1,45 @@
+package events
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	json "github.com/goccy/go-json"
+)
+
+// FileSink appends events to a newline-delimited JSON file, one event per
+// line. It mostly suits tests and local setups.
+type FileSink struct {
+	mx sync.Mutex
+	f  *os.File
+}
+
+var _ Sink = (*FileSink)(nil)
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{
+		f: f,
+	}, nil
+}
+
+func (s *FileSink) Send(_ context.Context, evt *Event) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// httpTimeout is the maximum duration of an event delivery to an HTTP sink.
+const httpTimeout = 10 * time.Second
+
+// HTTPSink POSTs events to an HTTP webhook, in structured content mode.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+var _ Sink = (*HTTPSink)(nil)
+
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{
+		url: url,
+		client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   httpTimeout,
+		},
+	}
+}
+
+func (s *HTTPSink) Send(ctx context.Context, evt *Event) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json; charset=UTF-8")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s responded with status %d", s.url, res.StatusCode)
+	}
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	defaultSubject = "chall-manager"
+
+	// natsTimeout is the maximum duration of a connection or publication to
+	// NATS.
+	natsTimeout = 5 * time.Second
+)
+
+// NATSSink publishes events on a NATS subject.
+// It connects lazily, on the first event, then the client reconnects on its
+// own once the connection is lost. TLS is used with a tls:// URL, or when the
+// server requires it.
+type NATSSink struct {
+	url     string
+	subject string
+
+	mx sync.Mutex
+	nc *nats.Conn
+}
+
+var _ Sink = (*NATSSink)(nil)
+
+// NewNATSSink returns a NATSSink publishing to the server at url, e.g.
+// nats://[user:pass@]host:port. A username without password is used as a
+// token.
+func NewNATSSink(url, subject string) *NATSSink {
+	return &NATSSink{
+		url:     url,
+		subject: subject,
+	}
+}
+
+func (s *NATSSink) Send(ctx context.Context, evt *Event) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	nc, err := s.conn()
+	if err != nil {
+		return err
+	}
+	if err := nc.Publish(s.subject, b); err != nil {
+		return err
+	}
+
+	// Make sure the server got it, rather than only buffered
+	ctx, cancel := context.WithTimeout(ctx, natsTimeout)
+	defer cancel()
+	return nc.FlushWithContext(ctx)
+}
+
+func (s *NATSSink) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.nc == nil {
+		return nil
+	}
+	// Events are flushed on Send, no need to wait for a lost server
+	s.nc.Close()
+	s.nc = nil
+	return nil
+}
+
+// conn returns the connection to the server, connecting if not yet.
+func (s *NATSSink) conn() (*nats.Conn, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.nc != nil {
+		return s.nc, nil
+	}
+	nc, err := nats.Connect(s.url,
+		nats.Name("chall-manager"),
+		nats.Timeout(natsTimeout),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.nc = nc
+	return nc, nil
+}
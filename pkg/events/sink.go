@@ -0,0 +1,38 @@
+package events
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// NewSink returns the Sink that fits an URL, depending on its scheme:
+//   - http(s)://host/path POSTs the events to an HTTP webhook ;
+//   - nats://[user:pass@]host:port/subject publishes them on a NATS subject
+//     (default to "chall-manager"), or tls://... to require TLS ;
+//   - file:///path/to/events.ndjson appends them to a newline-delimited JSON
+//     file.
+func NewSink(raw string) (Sink, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTPSink(raw), nil
+	case "nats", "tls":
+		subject := strings.Trim(u.Path, "/")
+		if subject == "" {
+			subject = defaultSubject
+		}
+		srv := &url.URL{
+			Scheme: u.Scheme,
+			User:   u.User,
+			Host:   u.Host,
+		}
+		return NewNATSSink(srv.String(), subject), nil
+	case "file":
+		return NewFileSink(u.Path)
+	}
+	return nil, fmt.Errorf("unsupported events sink scheme %q", u.Scheme)
+}
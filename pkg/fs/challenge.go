@@ -10,18 +10,89 @@ import (
 
 	"github.com/ctfer-io/chall-manager/global"
 	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/pool"
 )
 
 // Challenge is the internal model of an API Challenge as it is stored on the
 // filesystem (at `<global.Conf.Directory>/chall/hash(<id>)/info.json`).
 type Challenge struct {
-	ID         string            `json:"id"`
-	Scenario   string            `json:"scenario"`
-	Until      *time.Time        `json:"until,omitempty"`
-	Timeout    *time.Duration    `json:"timeout,omitempty"`
-	Additional map[string]string `json:"additional,omitempty"`
-	Min        int64             `json:"min"`
-	Max        int64             `json:"max"`
+	ID                string            `json:"id"`
+	Scenario          string            `json:"scenario"`
+	Until             *time.Time        `json:"until,omitempty"`
+	Timeout           *time.Duration    `json:"timeout,omitempty"`
+	Additional        map[string]string `json:"additional,omitempty"`
+	Min               int64             `json:"min"`
+	Max               int64             `json:"max"`
+	Schedule          []pool.Window     `json:"schedule,omitempty"`
+	Autoscale         bool              `json:"autoscale,omitempty"`
+	Weight            int64             `json:"weight,omitempty"`
+	PoolMaxAge        *time.Duration    `json:"pool_max_age,omitempty"`
+	Variants          []pool.Variant    `json:"variants,omitempty"`
+	ExcludePausedTime bool              `json:"exclude_paused_time,omitempty"`
+	SourceQuota       int64             `json:"source_quota,omitempty"`
+	Sharing           string            `json:"sharing,omitempty"`
+	GroupSize         int64             `json:"group_size,omitempty"`
+	MaxRenewals       int64             `json:"max_renewals,omitempty"`
+	MaxLifetime       *time.Duration    `json:"max_lifetime,omitempty"`
+	RenewWindow       *time.Duration    `json:"renew_window,omitempty"`
+}
+
+// Sharing modes of the instances of a challenge.
+// Challenges saved before sharing existed have none, and are per source.
+const (
+	SharingPerSource = "per_source"
+	SharingGlobal    = "shared_global"
+	SharingGroups    = "shared_groups"
+)
+
+// Shared returns whether the instances of the challenge can be claimed by
+// several sources.
+func (chall *Challenge) Shared() bool {
+	return chall.Sharing == SharingGlobal || chall.Sharing == SharingGroups
+}
+
+// SharedBy returns whether an instance claimed by n sources can be claimed by
+// one more.
+func (chall *Challenge) SharedBy(n int) bool {
+	switch chall.Sharing {
+	case SharingGlobal:
+		return true
+	case SharingGroups:
+		return int64(n) < chall.GroupSize
+	default:
+		return n == 0
+	}
+}
+
+// Holds returns whether an instance claimed by n sources complies with the
+// sharing mode, e.g. once it changed.
+func (chall *Challenge) Holds(n int) bool {
+	return n == 0 || chall.SharedBy(n-1)
+}
+
+// Deadline returns the date an instance created at since reaches its maximum
+// lifetime, or nil if the challenge has none.
+func (chall *Challenge) Deadline(since time.Time) *time.Time {
+	if chall.MaxLifetime == nil {
+		return nil
+	}
+	d := since.Add(*chall.MaxLifetime)
+	return &d
+}
+
+// Units returns the capacity budget units an instance of the challenge
+// consumes. It defaults to 1 when no weight is defined.
+func (chall *Challenge) Units() int64 {
+	if chall.Weight <= 0 {
+		return 1
+	}
+	return chall.Weight
+}
+
+// PoolBounds returns the pool boundaries that apply at the given time,
+// i.e. the ones of the active schedule window or the static Min/Max.
+func (chall *Challenge) PoolBounds(t time.Time) (minVal, maxVal int64) {
+	return pool.Bounds(chall.Schedule, t, chall.Min, chall.Max)
 }
 
 func challengeDirectory(id string) string {
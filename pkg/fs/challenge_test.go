@@ -0,0 +1,140 @@
+package fs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_U_SharedBy(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Challenge *fs.Challenge
+		Sources   int
+		Expected  bool
+	}{
+		"legacy-unclaimed": {
+			Challenge: &fs.Challenge{},
+			Sources:   0,
+			Expected:  true,
+		},
+		"legacy-claimed": {
+			Challenge: &fs.Challenge{},
+			Sources:   1,
+			Expected:  false,
+		},
+		"per-source-claimed": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingPerSource},
+			Sources:   1,
+			Expected:  false,
+		},
+		"global": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingGlobal},
+			Sources:   1000,
+			Expected:  true,
+		},
+		"groups-room-left": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingGroups, GroupSize: 3},
+			Sources:   2,
+			Expected:  true,
+		},
+		"groups-full": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingGroups, GroupSize: 3},
+			Sources:   3,
+			Expected:  false,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.Expected, tt.Challenge.SharedBy(tt.Sources))
+		})
+	}
+}
+
+func Test_U_Holds(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Challenge *fs.Challenge
+		Sources   int
+		Expected  bool
+	}{
+		"pooled": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingPerSource},
+			Sources:   0,
+			Expected:  true,
+		},
+		"per-source": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingPerSource},
+			Sources:   1,
+			Expected:  true,
+		},
+		"per-source-over": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingPerSource},
+			Sources:   2,
+			Expected:  false,
+		},
+		"groups-full": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingGroups, GroupSize: 3},
+			Sources:   3,
+			Expected:  true,
+		},
+		"groups-over": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingGroups, GroupSize: 2},
+			Sources:   3,
+			Expected:  false,
+		},
+		"global": {
+			Challenge: &fs.Challenge{Sharing: fs.SharingGlobal},
+			Sources:   1000,
+			Expected:  true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.Expected, tt.Challenge.Holds(tt.Sources))
+		})
+	}
+}
+
+func Test_U_Deadline(t *testing.T) {
+	t.Parallel()
+
+	since := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	hour := time.Hour
+
+	var tests = map[string]struct {
+		Challenge *fs.Challenge
+		Expected  *time.Time
+	}{
+		"no-max-lifetime": {
+			Challenge: &fs.Challenge{},
+			Expected:  nil,
+		},
+		"max-lifetime": {
+			Challenge: &fs.Challenge{MaxLifetime: &hour},
+			Expected:  ptr(since.Add(time.Hour)),
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.Expected, tt.Challenge.Deadline(since))
+		})
+	}
+}
+
+func ptr[T any](t T) *T {
+	return &t
+}
@@ -1,8 +1,11 @@
 package fs
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	json "github.com/goccy/go-json"
@@ -22,6 +25,49 @@ type Instance struct {
 	ConnectionInfo string            `json:"connection_info"`
 	Flags          []string          `json:"flags,omitempty"`
 	Additional     map[string]string `json:"additional,omitempty"`
+	Healthcheck    string            `json:"healthcheck,omitempty"`
+	Readiness      []string          `json:"readiness,omitempty"`
+	Variant        string            `json:"variant,omitempty"`
+	Status         string            `json:"status,omitempty"`
+	StatusMessage  string            `json:"status_message,omitempty"`
+	PausedAt       *time.Time        `json:"paused_at,omitempty"`
+	Renewals       int64             `json:"renewals,omitempty"`
+	WarnedUntil    *time.Time        `json:"warned_until,omitempty"`
+}
+
+// Statuses of an Instance along its lifecycle.
+// Instances saved before statuses existed have none, and are ready.
+const (
+	StatusReady        = "ready"
+	StatusProvisioning = "provisioning"
+	StatusUpdating     = "updating"
+	StatusFailed       = "failed"
+	StatusDeleting     = "deleting"
+	StatusPaused       = "paused"
+	StatusStarting     = "starting"
+)
+
+// SetStatus sets the status of the instance along its message, if any.
+func (ist *Instance) SetStatus(status, message string) {
+	ist.Status = status
+	ist.StatusMessage = message
+}
+
+// Settle sets the status of the instance once an operation on it succeeded,
+// i.e. ready or paused.
+func (ist *Instance) Settle() {
+	if ist.PausedAt != nil {
+		ist.SetStatus(StatusPaused, "")
+		return
+	}
+	ist.SetStatus(StatusReady, "")
+}
+
+// Handover resets the dates of a pooled instance claimed at now, such that
+// its lifetime counts from its claim rather than from its deployment.
+func (ist *Instance) Handover(now time.Time) {
+	ist.Since = now
+	ist.LastRenew = now
 }
 
 // Claim a challenge instance (by its identity) for a source.
@@ -33,26 +79,111 @@ func Claim(challID, identity, sourceID string) error {
 	return fsist.Claim(sourceID)
 }
 
-// Claim the instance for a source.
-func (ist *Instance) Claim(sourceID string) error {
-	claimPath := filepath.Join(instanceDirectory(ist.ChallengeID, ist.Identity), claimFile)
-	return os.WriteFile(claimPath, []byte(sourceID), 0600)
+// Claim the instance for sources, in addition to those already claiming it
+// if it is shared.
+func (ist *Instance) Claim(sourceIDs ...string) error {
+	claims, err := LookupClaims(ist.ChallengeID, ist.Identity)
+	if err != nil {
+		if _, ok := err.(*errs.InstanceExist); !ok {
+			return err
+		}
+	}
+	for _, sourceID := range sourceIDs {
+		if !slices.Contains(claims, sourceID) {
+			claims = append(claims, sourceID)
+		}
+	}
+	return writeClaims(ist.ChallengeID, ist.Identity, claims)
 }
 
-// LookupClaim returns the source that claims an instance.
+// Unclaim a challenge instance (by its identity) for a source.
+func Unclaim(challID, identity, sourceID string) error {
+	fsist := &Instance{
+		ChallengeID: challID,
+		Identity:    identity,
+	}
+	return fsist.Unclaim(sourceID)
+}
+
+// Unclaim the instance for a source, such that it is no longer shared with it.
+// The last source can't unclaim it, the instance has to be deleted instead,
+// elseway it would be considered pooled.
+func (ist *Instance) Unclaim(sourceID string) error {
+	claims, err := LookupClaims(ist.ChallengeID, ist.Identity)
+	if err != nil {
+		return err
+	}
+	claims = slices.DeleteFunc(claims, func(src string) bool {
+		return src == sourceID
+	})
+	if len(claims) == 0 {
+		return errors.New("can't unclaim an instance for its last source")
+	}
+	return writeClaims(ist.ChallengeID, ist.Identity, claims)
+}
+
+// Transfer the claim of the instance from a source to another, which takes
+// its place among the sources it is shared with.
+// Errors could be of type [*errors.InstanceExist] if the former source does
+// not claim it, or if the target one already does.
+func (ist *Instance) Transfer(fromSourceID, toSourceID string) error {
+	claims, err := LookupClaims(ist.ChallengeID, ist.Identity)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(claims, toSourceID) {
+		return &errs.InstanceExist{
+			ChallengeID: ist.ChallengeID,
+			SourceID:    toSourceID,
+			Exist:       true,
+		}
+	}
+	i := slices.Index(claims, fromSourceID)
+	if i < 0 {
+		return &errs.InstanceExist{
+			ChallengeID: ist.ChallengeID,
+			SourceID:    fromSourceID,
+			Exist:       false,
+		}
+	}
+	claims[i] = toSourceID
+	return writeClaims(ist.ChallengeID, ist.Identity, claims)
+}
+
+func writeClaims(challID, identity string, claims []string) error {
+	claimPath := filepath.Join(instanceDirectory(challID, identity), claimFile)
+	return os.WriteFile(claimPath, []byte(strings.Join(claims, "\n")), 0600)
+}
+
+// LookupClaim returns the source that claims an instance, i.e. the first one
+// if it is shared.
 func LookupClaim(challID, identity string) (string, error) {
+	claims, err := LookupClaims(challID, identity)
+	if err != nil {
+		return "", err
+	}
+	return claims[0], nil
+}
+
+// LookupClaims returns the sources that claim an instance, in the order they
+// claimed it.
+// Errors could be of type [*errors.InstanceExist] if it is not claimed, i.e.
+// it is pooled.
+func LookupClaims(challID, identity string) ([]string, error) {
 	b, err := os.ReadFile(filepath.Join(instanceDirectory(challID, identity), claimFile))
 	if err == nil {
-		return string(b), nil // exist
+		// One source per line, such that claims of a single source remain
+		// compatible.
+		return strings.Split(string(b), "\n"), nil // exist
 	}
 	if os.IsNotExist(err) {
-		return "", &errs.InstanceExist{
+		return nil, &errs.InstanceExist{
 			ChallengeID: challID,
 			SourceID:    identity, // XXX should not use the source ID
 			Exist:       false,
 		}
 	}
-	return "", err
+	return nil, err
 }
 
 // FindInstance loads all Instances until finding the one claimed by a source.
@@ -74,7 +205,7 @@ func FindInstance(challID, sourceID string) (string, error) {
 		return "", err
 	}
 	for _, ist := range ists {
-		src, err := LookupClaim(challID, ist)
+		claims, err := LookupClaims(challID, ist)
 		if err != nil {
 			if _, ok := err.(*errs.InstanceExist); ok {
 				// In pool
@@ -82,7 +213,7 @@ func FindInstance(challID, sourceID string) (string, error) {
 			}
 			return "", err
 		}
-		if src == sourceID {
+		if slices.Contains(claims, sourceID) {
 			return ist, nil
 		}
 	}
@@ -0,0 +1,123 @@
+package fs_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/global"
+	errs "github.com/ctfer-io/chall-manager/pkg/errors"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+func Test_U_Claims(t *testing.T) {
+	// Not parallel as it sets the global directory
+	global.Conf.Directory = t.TempDir()
+
+	fsist := &fs.Instance{
+		ChallengeID: "chall",
+		Identity:    "identity",
+	}
+	require.NoError(t, fsist.Save())
+
+	// Pooled instances are not claimed
+	_, err := fs.LookupClaims(fsist.ChallengeID, fsist.Identity)
+	assert.IsType(t, &errs.InstanceExist{}, err)
+
+	// Claims accumulate, in order and without duplicates
+	require.NoError(t, fsist.Claim("a"))
+	require.NoError(t, fsist.Claim("b", "a", "c"))
+	claims, err := fs.LookupClaims(fsist.ChallengeID, fsist.Identity)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, claims)
+
+	id, err := fs.FindInstance(fsist.ChallengeID, "b")
+	require.NoError(t, err)
+	assert.Equal(t, fsist.Identity, id)
+
+	// Sources leave, but the last one
+	require.NoError(t, fsist.Unclaim("a"))
+	require.NoError(t, fsist.Unclaim("c"))
+	assert.Error(t, fsist.Unclaim("b"))
+	src, err := fs.LookupClaim(fsist.ChallengeID, fsist.Identity)
+	require.NoError(t, err)
+	assert.Equal(t, "b", src)
+
+	_, err = fs.FindInstance(fsist.ChallengeID, "a")
+	assert.IsType(t, &errs.InstanceExist{}, err)
+}
+
+func Test_U_Transfer(t *testing.T) {
+	// Not parallel as it sets the global directory
+	global.Conf.Directory = t.TempDir()
+
+	fsist := &fs.Instance{
+		ChallengeID: "chall",
+		Identity:    "identity",
+	}
+	require.NoError(t, fsist.Save())
+
+	// Pooled instances can't be transferred
+	assert.IsType(t, &errs.InstanceExist{}, fsist.Transfer("a", "b"))
+
+	// The target source takes the place of the former one
+	require.NoError(t, fsist.Claim("a", "b", "c"))
+	require.NoError(t, fsist.Transfer("b", "d"))
+	claims, err := fs.LookupClaims(fsist.ChallengeID, fsist.Identity)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "d", "c"}, claims)
+
+	// The former source must claim it, and the target one must not
+	err = fsist.Transfer("b", "e")
+	if assert.IsType(t, &errs.InstanceExist{}, err) {
+		assert.False(t, err.(*errs.InstanceExist).Exist)
+	}
+	err = fsist.Transfer("a", "c")
+	if assert.IsType(t, &errs.InstanceExist{}, err) {
+		assert.True(t, err.(*errs.InstanceExist).Exist)
+	}
+}
+
+func Test_U_Handover(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	hour := time.Hour
+	fschall := &fs.Challenge{MaxLifetime: &hour}
+
+	// Pooled for longer than the challenge maximum lifetime
+	fsist := &fs.Instance{
+		Since:     now.Add(-2 * time.Hour),
+		LastRenew: now.Add(-2 * time.Hour),
+	}
+	require.True(t, fschall.Deadline(fsist.Since).Before(now))
+
+	// Once claimed, its lifetime starts over
+	fsist.Handover(now)
+	assert.Equal(t, now, fsist.Since)
+	assert.Equal(t, now, fsist.LastRenew)
+	assert.Equal(t, now.Add(time.Hour), *fschall.Deadline(fsist.Since))
+}
+
+func Test_U_LegacyClaim(t *testing.T) {
+	// Not parallel as it sets the global directory
+	global.Conf.Directory = t.TempDir()
+
+	fsist := &fs.Instance{
+		ChallengeID: "chall",
+		Identity:    "identity",
+	}
+	require.NoError(t, fsist.Save())
+
+	// Claims of a single source were written as is
+	dir := filepath.Join(global.Conf.Directory, "chall", fs.Hash(fsist.ChallengeID), "instance", fsist.Identity)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "claim"), []byte("source"), 0600))
+
+	claims, err := fs.LookupClaims(fsist.ChallengeID, fsist.Identity)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"source"}, claims)
+}
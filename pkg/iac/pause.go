@@ -0,0 +1,43 @@
+package iac
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"go.uber.org/multierr"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Pause re-applies the scenario of an instance with its workloads scaled down
+// if paused, or back up elseway.
+// The instance state and outputs are exported, even on failure, but it is up
+// to the caller to save it.
+func Pause(ctx context.Context, fschall *fs.Challenge, fsist *fs.Instance, paused bool) error {
+	ctx, span := global.Tracer.Start(ctx, "pause-instance")
+	defer span.End()
+
+	stack, err := LoadStack(ctx, fschall.Scenario, fsist.Identity)
+	if err != nil {
+		return err
+	}
+	if err := stack.Import(ctx, fsist); err != nil {
+		return err
+	}
+	if err := Additional(ctx, stack, fschall.Additional, fsist.Additional); err != nil {
+		return err
+	}
+	if err := Paused(ctx, stack, paused); err != nil {
+		return err
+	}
+	if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: fsist.Identity}); err != nil {
+		return err
+	}
+
+	sr, err := stack.Up(ctx)
+	if err != nil {
+		return multierr.Combine(err, stack.ExportState(ctx, fsist))
+	}
+	return stack.Export(ctx, sr, fsist)
+}
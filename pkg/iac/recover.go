@@ -0,0 +1,246 @@
+package iac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Recovery policies, i.e., what to do with an instance once its stack has
+// been recovered.
+const (
+	// PolicyResume re-applies the scenario such that the instance is back up.
+	PolicyResume = "resume"
+	// PolicyDestroy destroys what remains of the instance.
+	PolicyDestroy = "destroy"
+)
+
+// Reasons for an instance to require recovery.
+const (
+	ReasonPendingOperations = "pending_operations"
+	ReasonMissingState      = "missing_state"
+)
+
+// NeedsRecovery returns why an instance requires recovery, or an empty string
+// if it does not.
+// This happens when chall-manager stopped during a stack update or destroy:
+// the exported state then contains pending operations, or it could not be
+// exported at all.
+func NeedsRecovery(fsist *fs.Instance) string {
+	if fsist.State == nil {
+		return ReasonMissingState
+	}
+	pending, err := pendingOperations(fsist.State)
+	if err != nil || len(pending) != 0 {
+		// An unreadable state is dealt with as if operations were pending,
+		// recovery will then surface the error.
+		return ReasonPendingOperations
+	}
+	return ""
+}
+
+// Recover unlocks the stack of an instance, imports its last known state free
+// of pending operations, refreshes it against the actual resources, then
+// resumes or destroys it according to the policy.
+// When resumed, the instance is saved. When destroyed, it is deleted.
+func Recover(ctx context.Context, policy string, fschall *fs.Challenge, fsist *fs.Instance) error {
+	logger := global.Log()
+
+	stack, err := LoadStack(ctx, fschall.Scenario, fsist.Identity)
+	if err != nil {
+		return err
+	}
+	if err := Additional(ctx, stack, fschall.Additional, fsist.Additional); err != nil {
+		return err
+	}
+	if err := Paused(ctx, stack, fsist.PausedAt != nil); err != nil {
+		return err
+	}
+	if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: fsist.Identity}); err != nil {
+		return err
+	}
+
+	// Release the lock held by the interrupted update, if any.
+	// It fails if no update is running, which is the expected case.
+	if err := stack.pas.Cancel(ctx); err != nil {
+		logger.Debug(ctx, "canceling stack update", zap.Error(err))
+	}
+
+	// When the state was not exported, the backend may still know it
+	state := fsist.State
+	if state == nil {
+		udp, err := stack.pas.Export(ctx)
+		if err == nil && len(udp.Deployment) != 0 {
+			state = udp.Deployment
+		}
+	}
+
+	if state != nil {
+		clean, pending, err := clearPendingOperations(state)
+		if err != nil {
+			return err
+		}
+		for _, op := range pending {
+			// Resources pending creation may exist without being tracked,
+			// so could not be destroyed by chall-manager.
+			logger.Warn(ctx, "dropping pending operation from state",
+				zap.String("urn", string(op.Resource.URN)),
+				zap.String("type", string(op.Type)),
+			)
+		}
+		if err := stack.pas.Import(ctx, apitype.UntypedDeployment{
+			Version:    3,
+			Deployment: clean,
+		}); err != nil {
+			return err
+		}
+		if _, err := stack.pas.Refresh(ctx, optrefresh.ClearPendingCreates()); err != nil {
+			return err
+		}
+	}
+
+	switch policy {
+	case PolicyResume, "":
+		sr, err := stack.Up(ctx)
+		if err != nil {
+			// Keep the recovered state for later attempts
+			fsist.SetStatus(fs.StatusFailed, "recovery failed")
+			return multierr.Combine(err, stack.ExportState(ctx, fsist), fsist.Save())
+		}
+		if err := stack.Export(ctx, sr, fsist); err != nil {
+			return err
+		}
+		fsist.Settle()
+		return fsist.Save()
+
+	case PolicyDestroy:
+		if err := stack.Down(ctx); err != nil {
+			return err
+		}
+		return fsist.Delete()
+	}
+	return fmt.Errorf("unhandled recovery policy: %s", policy)
+}
+
+// deploying holds the identities of the stacks being deployed that are not
+// saved on the filesystem yet.
+var deploying sync.Map // identity -> struct{}
+
+// Deploying flags a stack as being deployed for an identity that is not saved
+// on the filesystem yet, such that it is not mistaken for an orphan.
+// It returns the function to call once the instance is saved, or given up.
+func Deploying(id string) (done func()) {
+	deploying.Store(id, struct{}{})
+	return func() {
+		deploying.Delete(id)
+	}
+}
+
+// Orphans returns the identities of the stacks of a scenario's project that
+// have not been deployed since a date, and are not being deployed.
+// The stacks that are not known of the filesystem afterwards are those of
+// instances that never got saved, e.g., because chall-manager stopped during
+// their deployment. The filesystem must be read after this call, elseway a
+// stack saved in the meantime would be mistaken for an orphan.
+func Orphans(ctx context.Context, scenario string, before time.Time) ([]string, error) {
+	ws, _, err := loadWorkspace(ctx, scenario)
+	if err != nil {
+		return nil, err
+	}
+	sums, err := ws.ListStacks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orphans := []string{}
+	for _, sum := range sums {
+		// Fully qualified names depend on the backend
+		id := sum.Name[strings.LastIndex(sum.Name, "/")+1:]
+		if _, ok := deploying.Load(id); ok {
+			continue
+		}
+		// A stack that never completed an update has no last update date
+		if sum.LastUpdate != "" {
+			last, err := time.Parse(time.RFC3339, sum.LastUpdate)
+			if err != nil || !last.Before(before) {
+				continue
+			}
+		}
+		orphans = append(orphans, id)
+	}
+	return orphans, nil
+}
+
+// DestroyOrphan destroys a stack that has no instance, then removes it.
+func DestroyOrphan(ctx context.Context, fschall *fs.Challenge, id string) error {
+	stack, err := LoadStack(ctx, fschall.Scenario, id)
+	if err != nil {
+		return err
+	}
+	if err := Additional(ctx, stack, fschall.Additional, nil); err != nil {
+		return err
+	}
+	if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: id}); err != nil {
+		return err
+	}
+	if err := stack.pas.Cancel(ctx); err != nil {
+		global.Log().Debug(ctx, "canceling stack update", zap.Error(err))
+	}
+	if _, err := stack.pas.Refresh(ctx, optrefresh.ClearPendingCreates()); err != nil {
+		return err
+	}
+	if err := stack.Down(ctx); err != nil {
+		return err
+	}
+	return stack.pas.Workspace().RemoveStack(ctx, stack.pas.Name())
+}
+
+// pendingOperations returns the pending operations of a state.
+func pendingOperations(state any) ([]apitype.OperationV2, error) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	dep := struct {
+		PendingOperations []apitype.OperationV2 `json:"pending_operations"`
+	}{}
+	if err := json.Unmarshal(b, &dep); err != nil {
+		return nil, err
+	}
+	return dep.PendingOperations, nil
+}
+
+// clearPendingOperations removes the pending operations from a state, without
+// altering anything else. It returns the resulting state and the removed
+// operations.
+func clearPendingOperations(state any) (json.RawMessage, []apitype.OperationV2, error) {
+	pending, err := pendingOperations(state)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, nil, err
+	}
+	dep := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &dep); err != nil {
+		return nil, nil, err
+	}
+	delete(dep, "pending_operations")
+	clean, err := json.Marshal(dep)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clean, pending, nil
+}
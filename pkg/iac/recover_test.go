@@ -0,0 +1,60 @@
+package iac_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+	"github.com/ctfer-io/chall-manager/pkg/iac"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_U_NeedsRecovery(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		State    any
+		Expected string
+	}{
+		"missing-state": {
+			State:    nil,
+			Expected: iac.ReasonMissingState,
+		},
+		"clean-exported": {
+			State:    json.RawMessage(`{"manifest":{},"resources":[]}`),
+			Expected: "",
+		},
+		"clean-loaded": {
+			State: map[string]any{
+				"manifest":           map[string]any{},
+				"pending_operations": []any{},
+			},
+			Expected: "",
+		},
+		"pending-operations": {
+			State: map[string]any{
+				"manifest": map[string]any{},
+				"pending_operations": []any{
+					map[string]any{
+						"resource": map[string]any{
+							"urn": "urn:pulumi:stack::project::kubernetes:core/v1:Pod::pod",
+						},
+						"type": "creating",
+					},
+				},
+			},
+			Expected: iac.ReasonPendingOperations,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			reason := iac.NeedsRecovery(&fs.Instance{
+				State: tt.State,
+			})
+			assert.Equal(t, tt.Expected, reason)
+		})
+	}
+}
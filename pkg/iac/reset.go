@@ -0,0 +1,93 @@
+package iac
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/auto"
+	"go.uber.org/multierr"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/fs"
+)
+
+// Reset destroys the resources of an instance, then deploys them again from
+// scratch under the identity id.
+// When id differs from the current identity, the instance is moved under it
+// and claimed for the source before being deployed, such that it is recovered
+// if chall-manager stops meanwhile.
+// The instance state and outputs are exported, even on failure, but it is up
+// to the caller to save it.
+func Reset(ctx context.Context, fschall *fs.Challenge, fsist *fs.Instance, sourceID, id string) error {
+	ctx, span := global.Tracer.Start(ctx, "reset-instance")
+	defer span.End()
+
+	// Destroy the current resources
+	stack, err := LoadStack(ctx, fschall.Scenario, fsist.Identity)
+	if err != nil {
+		return err
+	}
+	if fsist.State != nil {
+		if err := stack.Import(ctx, fsist); err != nil {
+			return err
+		}
+	}
+	if err := Additional(ctx, stack, fschall.Additional, fsist.Additional); err != nil {
+		return err
+	}
+	if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: fsist.Identity}); err != nil {
+		return err
+	}
+	if err := stack.Down(ctx); err != nil {
+		return multierr.Combine(err, stack.ExportState(ctx, fsist))
+	}
+
+	if id == fsist.Identity {
+		if err := multierr.Combine(
+			stack.ExportState(ctx, fsist),
+			fsist.Save(),
+		); err != nil {
+			return err
+		}
+	} else {
+		// Move the instance under its new identity
+		if err := stack.pas.Workspace().RemoveStack(ctx, stack.pas.Name()); err != nil {
+			return err
+		}
+		previous := &fs.Instance{
+			ChallengeID: fsist.ChallengeID,
+			Identity:    fsist.Identity,
+		}
+		fsist.Identity = id
+		fsist.State = nil
+		if err := multierr.Combine(
+			fsist.Save(),
+			fsist.Claim(sourceID),
+		); err != nil {
+			return err
+		}
+		if err := previous.Delete(); err != nil {
+			return err
+		}
+
+		stack, err = LoadStack(ctx, fschall.Scenario, id)
+		if err != nil {
+			return err
+		}
+		if err := Additional(ctx, stack, fschall.Additional, fsist.Additional); err != nil {
+			return err
+		}
+		if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: id}); err != nil {
+			return err
+		}
+	}
+
+	// Then deploy them again
+	if err := Paused(ctx, stack, fsist.PausedAt != nil); err != nil {
+		return err
+	}
+	sr, err := stack.Up(ctx)
+	if err != nil {
+		return multierr.Combine(err, stack.ExportState(ctx, fsist))
+	}
+	return stack.Export(ctx, sr, fsist)
+}
@@ -7,6 +7,7 @@ import (
 	"maps"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/pkg/errors"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto"
@@ -45,23 +46,45 @@ func LoadStack(ctx context.Context, ref, id string) (*Stack, error) {
 	ctx, span := global.Tracer.Start(ctx, "loading-stack")
 	defer span.End()
 
+	ws, project, err := loadWorkspace(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build stack
+	stackName := auto.FullyQualifiedStackName("organization", project, id)
+	pas, err := auto.UpsertStack(ctx, stackName, ws)
+	if err != nil {
+		return nil, &errs.Scenario{
+			Ref: ref,
+			Sub: errors.Wrapf(err, "upsert stack %s", stackName),
+		}
+	}
+	return &Stack{
+		pas: pas,
+	}, nil
+}
+
+// loadWorkspace creates the Pulumi workspace of a scenario, and returns it
+// along its project name.
+func loadWorkspace(ctx context.Context, ref string) (auto.Workspace, string, error) {
 	// Load the scenario, validate its obvious content and pre-process it
 	dir, err := global.GetOCIManager().Load(ctx, ref)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// Get scenario's project name
 	b, err := loadPulumiProject(dir)
 	if err != nil {
-		return nil, &errs.Scenario{
+		return nil, "", &errs.Scenario{
 			Ref: ref,
 			Sub: errors.Wrap(err, "no Pulumi.yaml/Pulumi.yml file"),
 		}
 	}
 	var yml workspace.Project
 	if err := yaml.Unmarshal(b, &yml); err != nil {
-		return nil, &errs.Scenario{
+		return nil, "", &errs.Scenario{
 			Ref: ref,
 			Sub: errors.Wrap(err, "invalid Pulumi yaml content"),
 		}
@@ -76,24 +99,12 @@ func LoadStack(ctx context.Context, ref, id string) (*Stack, error) {
 		}),
 	)
 	if err != nil {
-		return nil, &errs.Scenario{
+		return nil, "", &errs.Scenario{
 			Ref: ref,
 			Sub: errors.Wrap(err, "new local workspace"),
 		}
 	}
-
-	// Build stack
-	stackName := auto.FullyQualifiedStackName("organization", yml.Name.String(), id)
-	pas, err := auto.UpsertStack(ctx, stackName, ws)
-	if err != nil {
-		return nil, &errs.Scenario{
-			Ref: ref,
-			Sub: errors.Wrapf(err, "upsert stack %s", stackName),
-		}
-	}
-	return &Stack{
-		pas: pas,
-	}, nil
+	return ws, yml.Name.String(), nil
 }
 
 // Additional packs the challenge and instance additional k=v entries together
@@ -115,6 +126,12 @@ func Additional(ctx context.Context, stack *Stack, challAdd, istAdd map[string]s
 	return stack.pas.SetConfig(ctx, "additional", auto.ConfigValue{Value: string(b)})
 }
 
+// Paused configures the stack such that the scenario scales its workloads
+// down, or back up. The SDK reads it from the reserved "paused" key.
+func Paused(ctx context.Context, stack *Stack, paused bool) error {
+	return stack.pas.SetConfig(ctx, "paused", auto.ConfigValue{Value: strconv.FormatBool(paused)})
+}
+
 type Result struct {
 	sub auto.UpResult
 }
@@ -173,9 +190,47 @@ func (stack *Stack) Export(ctx context.Context, res *Result, ist *fsapi.Instance
 		}
 	}
 
+	// The healthcheck is optional, and only used to check pooled instances.
+	healthcheck := ""
+	if hc, ok := res.sub.Outputs["healthcheck"]; ok {
+		if healthcheck, ok = hc.Value.(string); !ok {
+			return fmt.Errorf("invalid healthcheck type, should be a string")
+		}
+	}
+
+	// The readiness probes are optional, and checked before the instance is
+	// considered ready.
+	readiness := []string{}
+	if r, ok := res.sub.Outputs["readiness"]; ok && r.Value != nil {
+		probes, ok := r.Value.([]any)
+		if !ok {
+			return fmt.Errorf("invalid readiness type, should be an array")
+		}
+		for _, p := range probes {
+			probe, ok := p.(string)
+			if !ok {
+				return fmt.Errorf("invalid readiness probe type for %v, should be a string", p)
+			}
+			readiness = append(readiness, probe)
+		}
+	}
+
 	ist.State = udp.Deployment
 	ist.ConnectionInfo = coninfo.Value.(string)
 	ist.Flags = flags
+	ist.Healthcheck = healthcheck
+	ist.Readiness = readiness
+	return nil
+}
+
+// ExportState exports only the state of the stack into the instance, e.g.,
+// to keep track of what has been deployed before a failure.
+func (stack *Stack) ExportState(ctx context.Context, ist *fsapi.Instance) error {
+	udp, err := stack.pas.Export(ctx)
+	if err != nil {
+		return err
+	}
+	ist.State = udp.Deployment
 	return nil
 }
 
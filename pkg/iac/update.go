@@ -23,18 +23,34 @@ func Update(
 	fschall *fs.Challenge,
 	fsist *fs.Instance,
 ) error {
+	var update func(context.Context, string, *fs.Challenge, *fs.Instance) error
 	switch updateStrategy {
 	// default value such that pool claim is possible (elseway cyclic imports)
 	case "update_in_place", "":
-		return updateInPlace(ctx, previousScenario, fschall, fsist)
+		update = updateInPlace
 
 	case "blue_green":
-		return blueGreen(ctx, previousScenario, fschall, fsist)
+		update = blueGreen
 
 	case "recreate":
-		return recreate(ctx, previousScenario, fschall, fsist)
+		update = recreate
+
+	default:
+		panic(fmt.Errorf("unhandled update strategy, please open an issue: %s", updateStrategy))
 	}
-	panic(fmt.Errorf("unhandled update strategy, please open an issue: %s", updateStrategy))
+
+	// Let know the instance is being updated
+	fsist.SetStatus(fs.StatusUpdating, "")
+	if err := fsist.Save(); err != nil {
+		return err
+	}
+
+	if err := update(ctx, previousScenario, fschall, fsist); err != nil {
+		fsist.SetStatus(fs.StatusFailed, "update failed")
+		return multierr.Combine(err, fsist.Save())
+	}
+	fsist.Settle()
+	return nil
 }
 
 // Update-In-Place strategy loads the existing stack and state then moves to the
@@ -47,6 +63,7 @@ func updateInPlace(ctx context.Context, previousScenario string, fschall *fs.Cha
 func blueGreen(ctx context.Context, previousScenario string, fschall *fs.Challenge, fsist *fs.Instance) error {
 	oldID := fsist.Identity
 	fsist.Identity = identity.New()
+	defer Deploying(fsist.Identity)()
 
 	if err := up(ctx, previousScenario, fsist.Identity, fschall, fsist); err != nil {
 		return err
@@ -73,6 +90,9 @@ func up(ctx context.Context, scenario, id string, fschall *fs.Challenge, fsist *
 	if err := Additional(ctx, stack, fschall.Additional, fsist.Additional); err != nil {
 		return err
 	}
+	if err := Paused(ctx, stack, fsist.PausedAt != nil); err != nil {
+		return err
+	}
 	if err := stack.pas.SetConfig(ctx, "identity", auto.ConfigValue{Value: id}); err != nil {
 		return err
 	}
@@ -0,0 +1,101 @@
+// Package leader elects a single replica among all chall-manager ones to run
+// background jobs, e.g. the pool reconciliation.
+package leader
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/identity"
+)
+
+const (
+	electionPrefix = "/chall-manager/leader"
+
+	// retryDelay is the time to wait before campaigning again after an error.
+	retryDelay = 5 * time.Second
+)
+
+var leading atomic.Bool
+
+// IsLeader returns whether this replica is currently the leader.
+// Without etcd there is a single replica, which is always the leader.
+func IsLeader() bool {
+	return global.Conf.Etcd.Endpoint == "" || leading.Load()
+}
+
+// Run runs fn while this replica is the leader, until the context is done.
+// When leadership is lost (e.g. the etcd session expired), the context passed
+// to fn is canceled and the replica campaigns again, such that another replica
+// takes over if this one died.
+// Without etcd, fn is run right away.
+func Run(ctx context.Context, fn func(ctx context.Context)) {
+	if global.Conf.Etcd.Endpoint == "" {
+		fn(ctx)
+		return
+	}
+
+	logger := global.Log()
+	for {
+		if err := lead(ctx, fn); err != nil {
+			logger.Error(ctx, "leader election", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// lead campaigns for leadership then runs fn until it is lost.
+func lead(ctx context.Context, fn func(ctx context.Context)) error {
+	logger := global.Log()
+
+	election, sess, err := global.GetEtcdManager().Election(ctx, electionPrefix)
+	if err != nil {
+		return err
+	}
+
+	// Blocks until elected
+	if err := election.Campaign(ctx, candidate()); err != nil {
+		return err
+	}
+	logger.Info(ctx, "elected leader")
+	leading.Store(true)
+	defer leading.Store(false)
+
+	// Run until leadership is lost or the context is done
+	lctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-sess.Done():
+			logger.Warn(ctx, "lost leadership")
+			cancel()
+		case <-lctx.Done():
+		}
+	}()
+	fn(lctx)
+
+	// Let another replica take over right away
+	if err := election.Resign(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// candidate returns the value this replica campaigns with, for observability
+// of who the leader is.
+func candidate() string {
+	if hn, err := os.Hostname(); err == nil {
+		return hn
+	}
+	return identity.New()
+}
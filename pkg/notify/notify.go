@@ -0,0 +1,219 @@
+// Package notify delivers notifications about instances to webhooks, e.g. to
+// warn sources before their instance expires.
+// Notifications are persisted in an outbox until delivered, such that they
+// survive chall-manager restarts, and are retried with an exponential backoff.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"go.uber.org/zap"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/identity"
+)
+
+// Types of notification.
+const (
+	// TypeExpiring is sent before an instance expires.
+	TypeExpiring = "instance.expiring"
+	// TypeExpired is sent once an expired instance is deleted.
+	TypeExpired = "instance.expired"
+)
+
+// Headers of a webhook delivery.
+const (
+	HeaderSignature = "X-Chall-Manager-Signature"
+	HeaderEvent     = "X-Chall-Manager-Event"
+	HeaderDelivery  = "X-Chall-Manager-Delivery"
+)
+
+const (
+	// Timeout is the maximum duration of a single delivery attempt.
+	Timeout = 10 * time.Second
+
+	retryBase = 5 * time.Second
+	retryMax  = 10 * time.Minute
+)
+
+// Payload is the body of a notification, as sent to webhooks.
+type Payload struct {
+	ID          string     `json:"id"`
+	Type        string     `json:"type"`
+	ChallengeID string     `json:"challenge_id"`
+	SourceID    string     `json:"source_id"`
+	Until       *time.Time `json:"until,omitempty"`
+	At          time.Time  `json:"at"`
+}
+
+// Delivery is a notification payload to deliver to a webhook.
+type Delivery struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	Payload  *Payload  `json:"payload"`
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+}
+
+// Notifier delivers notifications to webhooks through its outbox.
+type Notifier struct {
+	Outbox   Outbox
+	Webhooks []string
+	// Secret signs the payloads, such that webhooks can authenticate them.
+	// If empty, payloads are not signed, rather than signed with an empty key.
+	Secret string
+	// MaxAttempts is the number of attempts after which a delivery is dropped.
+	// Default to 1.
+	MaxAttempts int
+	Client      *http.Client
+}
+
+// Notify persists a notification for every webhook. They are delivered on
+// the next Flush.
+func (n *Notifier) Notify(ctx context.Context, typ, challengeID, sourceID string, until *time.Time) error {
+	now := time.Now()
+	p := &Payload{
+		ID:          identity.New(),
+		Type:        typ,
+		ChallengeID: challengeID,
+		SourceID:    sourceID,
+		Until:       until,
+		At:          now,
+	}
+	for i, url := range n.Webhooks {
+		if err := n.Outbox.Push(ctx, &Delivery{
+			ID:      p.ID + "-" + strconv.Itoa(i),
+			URL:     url,
+			Payload: p,
+			Next:    now,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flush attempts the deliveries that are due. Failed ones are scheduled for a
+// later attempt, until they run out of attempts and are dropped.
+// It only returns an error if the outbox could not be read or written.
+func (n *Notifier) Flush(ctx context.Context) error {
+	logger := global.Log()
+
+	dels, err := n.Outbox.List(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, d := range dels {
+		if d.Next.After(now) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil // delivered on the next flush
+		}
+
+		err := n.deliver(ctx, d)
+		if err == nil {
+			if err := n.Outbox.Done(ctx, d.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		d.Attempts++
+		if d.Attempts >= n.MaxAttempts {
+			logger.Error(ctx, "dropping notification",
+				zap.String("url", d.URL),
+				zap.String("type", d.Payload.Type),
+				zap.Int("attempts", d.Attempts),
+				zap.Error(err),
+			)
+			if err := n.Outbox.Done(ctx, d.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		d.Next = now.Add(Backoff(d.Attempts))
+		logger.Warn(ctx, "delivering notification",
+			zap.String("url", d.URL),
+			zap.String("type", d.Payload.Type),
+			zap.Int("attempts", d.Attempts),
+			zap.Time("next", d.Next),
+			zap.Error(err),
+		)
+		if err := n.Outbox.Push(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) deliver(ctx context.Context, d *Delivery) error {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	b, err := json.Marshal(d.Payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.Payload.Type)
+	req.Header.Set(HeaderDelivery, d.ID)
+	if n.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(n.Secret, b))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s responded with status %d", d.URL, res.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the signature of a payload body, as sent in the
+// X-Chall-Manager-Signature header, i.e. its hex-encoded HMAC-SHA256 prefixed
+// with "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns whether a signature matches a payload body, in constant time.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Backoff returns the delay before the next attempt of a delivery that failed
+// the given number of times.
+func Backoff(attempts int) time.Duration {
+	d := retryBase
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= retryMax {
+			return retryMax
+		}
+	}
+	return d
+}
@@ -0,0 +1,176 @@
+package notify_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/pkg/notify"
+)
+
+func Test_U_Notifier(t *testing.T) {
+	t.Parallel()
+
+	const secret = "s3cr3t"
+	ctx := context.Background()
+
+	mx := sync.Mutex{}
+	received := []*notify.Payload{}
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		if !notify.Verify(secret, b, r.Header.Get(notify.HeaderSignature)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p := &notify.Payload{}
+		_ = json.Unmarshal(b, p)
+		received = append(received, p)
+	}))
+	defer srv.Close()
+
+	outbox := notify.NewFSOutbox(t.TempDir())
+	n := &notify.Notifier{
+		Outbox:      outbox,
+		Webhooks:    []string{srv.URL},
+		Secret:      secret,
+		MaxAttempts: 3,
+	}
+
+	until := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, n.Notify(ctx, notify.TypeExpiring, "chall", "source", &until))
+
+	// Failed deliveries are kept for later
+	require.NoError(t, n.Flush(ctx))
+	dels, err := outbox.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, dels, 1)
+	assert.Equal(t, 1, dels[0].Attempts)
+	assert.True(t, dels[0].Next.After(time.Now()))
+
+	// Not due yet, so not attempted
+	require.NoError(t, n.Flush(ctx))
+	dels, err = outbox.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, dels, 1)
+	assert.Equal(t, 1, dels[0].Attempts)
+
+	// Once due, it is delivered then removed
+	mx.Lock()
+	fail = false
+	mx.Unlock()
+	dels[0].Next = time.Now()
+	require.NoError(t, outbox.Push(ctx, dels[0]))
+	require.NoError(t, n.Flush(ctx))
+
+	dels, err = outbox.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, dels)
+	require.Len(t, received, 1)
+	assert.Equal(t, notify.TypeExpiring, received[0].Type)
+	assert.Equal(t, "chall", received[0].ChallengeID)
+	assert.Equal(t, "source", received[0].SourceID)
+	assert.True(t, until.Equal(*received[0].Until))
+}
+
+func Test_U_NotifierDrop(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	outbox := notify.NewFSOutbox(t.TempDir())
+	n := &notify.Notifier{
+		Outbox:      outbox,
+		Webhooks:    []string{srv.URL},
+		MaxAttempts: 1,
+	}
+	require.NoError(t, n.Notify(ctx, notify.TypeExpired, "chall", "source", nil))
+	require.NoError(t, n.Flush(ctx))
+
+	// Out of attempts, so dropped
+	dels, err := outbox.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, dels)
+}
+
+func Test_U_NotifierUnsigned(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	signatures := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		signatures <- r.Header.Get(notify.HeaderSignature)
+	}))
+	defer srv.Close()
+
+	n := &notify.Notifier{
+		Outbox:      notify.NewFSOutbox(t.TempDir()),
+		Webhooks:    []string{srv.URL},
+		MaxAttempts: 1,
+	}
+	require.NoError(t, n.Notify(ctx, notify.TypeExpired, "chall", "source", nil))
+	require.NoError(t, n.Flush(ctx))
+
+	// Without secret, payloads are not signed with an empty key
+	assert.Empty(t, <-signatures)
+}
+
+func Test_U_Verify(t *testing.T) {
+	t.Parallel()
+
+	body := []byte(`{"id":"1"}`)
+	sig := notify.Sign("secret", body)
+
+	assert.True(t, notify.Verify("secret", body, sig))
+	assert.False(t, notify.Verify("other", body, sig))
+	assert.False(t, notify.Verify("secret", []byte(`{"id":"2"}`), sig))
+}
+
+func Test_U_Backoff(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Attempts int
+		Expected time.Duration
+	}{
+		"first": {
+			Attempts: 1,
+			Expected: 5 * time.Second,
+		},
+		"third": {
+			Attempts: 3,
+			Expected: 20 * time.Second,
+		},
+		"capped": {
+			Attempts: 100,
+			Expected: 10 * time.Minute,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.Expected, notify.Backoff(tt.Attempts))
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package notify
+
+import (
+	"path/filepath"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/store"
+)
+
+const etcdPrefix = "/chall-manager/notify/outbox/"
+
+// Outbox persists the deliveries until they complete, such that they are
+// attempted again if chall-manager stops meanwhile (e.g. restarted,
+// rescheduled).
+type Outbox = store.Store[Delivery]
+
+// NewOutbox returns the Outbox that fits the configuration: in etcd when
+// configured, on the filesystem otherwise.
+func NewOutbox() Outbox {
+	return store.New(filepath.Join(global.Conf.Directory, "outbox"), etcdPrefix, deliveryID)
+}
+
+// NewFSOutbox returns an Outbox persisting each delivery as a JSON file of a
+// directory. It suits a single replica, as the filesystem is not shared.
+func NewFSOutbox(dir string) Outbox {
+	return store.NewFS(dir, deliveryID)
+}
+
+func deliveryID(d *Delivery) string {
+	return d.ID
+}
@@ -0,0 +1,172 @@
+package pool
+
+import (
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+const (
+	// BreakerThreshold is the number of consecutive failures after which
+	// the breaker opens.
+	BreakerThreshold = 5
+
+	// BreakerBaseBackoff is the backoff after a first failure. It doubles
+	// on every consecutive failure.
+	BreakerBaseBackoff = 10 * time.Second
+
+	// BreakerMaxBackoff caps the backoff.
+	BreakerMaxBackoff = 30 * time.Minute
+
+	// BreakerTrialTimeout is the duration after which a trial spin-up whose
+	// outcome was never reported (e.g. its replica stopped) is given up, such
+	// that another one is let through.
+	BreakerTrialTimeout = 15 * time.Minute
+)
+
+// BreakerState is the state of a [Breaker].
+type BreakerState int
+
+const (
+	// BreakerClosed lets spin-ups proceed.
+	BreakerClosed BreakerState = iota
+	// BreakerBackoff delays spin-ups after failures.
+	// Once the backoff is over, a single trial spin-up is let through.
+	BreakerBackoff
+	// BreakerOpen stops spin-ups after too many consecutive failures.
+	// Once the backoff is over, a single trial spin-up is let through.
+	BreakerOpen
+)
+
+// Breaker is a circuit breaker with exponential backoff for a pool spin-ups,
+// such that a failing scenario does not hammer the infrastructure.
+// It is safe for concurrent use.
+type Breaker struct {
+	mu       sync.Mutex
+	failures int
+	retryAt  time.Time
+	trialAt  time.Time
+}
+
+// Allow returns whether a spin-up can proceed at the given time.
+// When allowed after failures, the caller holds the single trial and must
+// report its outcome through [Breaker.Success], [Breaker.Failure] or
+// [Breaker.Cancel]. Spin-ups are blocked until then, or until the trial
+// times out (see [BreakerTrialTimeout]).
+func (b *Breaker) Allow(t time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures == 0 {
+		return true
+	}
+	if t.Before(b.retryAt) {
+		return false
+	}
+	if !b.trialAt.IsZero() && t.Before(b.trialAt.Add(BreakerTrialTimeout)) {
+		return false
+	}
+	b.trialAt = t
+	return true
+}
+
+// Success closes the breaker.
+func (b *Breaker) Success() {
+	b.Reset()
+}
+
+// Failure records a failed spin-up at the given time, and backs off.
+func (b *Breaker) Failure(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.retryAt = t.Add(Backoff(b.failures))
+	b.trialAt = time.Time{}
+}
+
+// Cancel gives back the trial held after [Breaker.Allow], when the spin-up
+// did not happen for another reason than a failure.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trialAt = time.Time{}
+}
+
+// Reset closes the breaker, e.g. after the scenario has been fixed.
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.retryAt = time.Time{}
+	b.trialAt = time.Time{}
+}
+
+// State returns the breaker state, the number of consecutive failures,
+// and when spin-ups can be retried.
+func (b *Breaker) State() (state BreakerState, failures int, retryAt time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.failures == 0:
+		state = BreakerClosed
+	case b.failures >= BreakerThreshold:
+		state = BreakerOpen
+	default:
+		state = BreakerBackoff
+	}
+	return state, b.failures, b.retryAt
+}
+
+// breakerJSON is the JSON representation of a [Breaker], such that it can be
+// shared between replicas.
+type breakerJSON struct {
+	Failures int       `json:"failures,omitempty"`
+	RetryAt  time.Time `json:"retry_at"`
+	TrialAt  time.Time `json:"trial_at"`
+}
+
+func (b *Breaker) MarshalJSON() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return json.Marshal(breakerJSON{
+		Failures: b.failures,
+		RetryAt:  b.retryAt,
+		TrialAt:  b.trialAt,
+	})
+}
+
+func (b *Breaker) UnmarshalJSON(data []byte) error {
+	bj := breakerJSON{}
+	if err := json.Unmarshal(data, &bj); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = bj.Failures
+	b.retryAt = bj.RetryAt
+	b.trialAt = bj.TrialAt
+	return nil
+}
+
+// Backoff returns the exponential backoff after a number of consecutive failures.
+func Backoff(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	d := BreakerBaseBackoff
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= BreakerMaxBackoff {
+			return BreakerMaxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,90 @@
+package pool_test
+
+import (
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+)
+
+func Test_U_Backoff(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Duration(0), pool.Backoff(0))
+	assert.Equal(t, pool.BreakerBaseBackoff, pool.Backoff(1))
+	assert.Equal(t, 4*pool.BreakerBaseBackoff, pool.Backoff(3))
+	assert.Equal(t, pool.BreakerMaxBackoff, pool.Backoff(100))
+}
+
+func Test_U_Breaker(t *testing.T) {
+	t.Parallel()
+
+	b := &pool.Breaker{}
+	now := time.Now()
+	assert.True(t, b.Allow(now))
+
+	// Backs off after a failure
+	b.Failure(now)
+	state, failures, retryAt := b.State()
+	assert.Equal(t, pool.BreakerBackoff, state)
+	assert.Equal(t, 1, failures)
+	assert.Equal(t, now.Add(pool.BreakerBaseBackoff), retryAt)
+	assert.False(t, b.Allow(now))
+	assert.True(t, b.Allow(retryAt))
+	assert.False(t, b.Allow(retryAt))
+
+	// Opens after too many consecutive failures
+	for range pool.BreakerThreshold - 1 {
+		b.Failure(now)
+	}
+	state, _, retryAt = b.State()
+	assert.Equal(t, pool.BreakerOpen, state)
+	assert.False(t, b.Allow(now))
+
+	// Once backed off, only a single trial is let through
+	assert.True(t, b.Allow(retryAt))
+	assert.False(t, b.Allow(retryAt))
+
+	// ... that can be given back if it did not happen
+	b.Cancel()
+	assert.True(t, b.Allow(retryAt))
+
+	// ... or that is given up if never reported
+	assert.False(t, b.Allow(retryAt.Add(pool.BreakerTrialTimeout-time.Second)))
+	assert.True(t, b.Allow(retryAt.Add(pool.BreakerTrialTimeout)))
+
+	// A successful trial closes it
+	b.Success()
+	state, failures, _ = b.State()
+	assert.Equal(t, pool.BreakerClosed, state)
+	assert.Equal(t, 0, failures)
+	assert.True(t, b.Allow(retryAt))
+}
+
+func Test_U_BreakerJSON(t *testing.T) {
+	t.Parallel()
+
+	b := &pool.Breaker{}
+	now := time.Now().UTC()
+	for range pool.BreakerThreshold {
+		b.Failure(now)
+	}
+	_, _, retryAt := b.State()
+	require.True(t, b.Allow(retryAt))
+
+	// Shared between replicas, it keeps its state and the trial held
+	data, err := json.Marshal(b)
+	require.NoError(t, err)
+	shared := &pool.Breaker{}
+	require.NoError(t, json.Unmarshal(data, shared))
+
+	state, failures, sretryAt := shared.State()
+	assert.Equal(t, pool.BreakerOpen, state)
+	assert.Equal(t, pool.BreakerThreshold, failures)
+	assert.True(t, retryAt.Equal(sretryAt))
+	assert.False(t, shared.Allow(retryAt))
+}
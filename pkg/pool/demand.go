@@ -0,0 +1,115 @@
+package pool
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// DemandWindow is the sliding window over which the claim rate is measured.
+const DemandWindow = 10 * time.Minute
+
+// Demand tracks the claim rate and spin-up latency of a pool, in order to
+// compute how many instances should be pre-provisioned to absorb the load.
+// It is safe for concurrent use.
+type Demand struct {
+	mu      sync.Mutex
+	claims  []time.Time
+	latency time.Duration
+}
+
+// Claim records an instance claim at the given time.
+func (d *Demand) Claim(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.claims = append(d.prune(t), t)
+}
+
+// SpinUp records the duration it took to deploy an instance.
+// The latency is smoothed with an exponentially weighted moving average.
+func (d *Demand) SpinUp(dur time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.latency == 0 {
+		d.latency = dur
+		return
+	}
+	d.latency = (3*d.latency + dur) / 4
+}
+
+// Latency returns the smoothed spin-up latency, or 0 if none was recorded.
+func (d *Demand) Latency() time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.latency
+}
+
+// Target returns the pool size to reach such that the expected claims during
+// a spin-up are covered, bounded between minVal and maxVal (0 means no upper
+// bound). When idle, it shrinks back to minVal.
+func (d *Demand) Target(t time.Time, minVal, maxVal int64) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.claims = d.prune(t)
+	return Target(int64(len(d.claims)), DemandWindow, d.latency, minVal, maxVal)
+}
+
+// demandJSON is the JSON representation of a [Demand], such that it can be
+// shared between replicas.
+type demandJSON struct {
+	Claims  []time.Time   `json:"claims,omitempty"`
+	Latency time.Duration `json:"latency,omitempty"`
+}
+
+func (d *Demand) MarshalJSON() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return json.Marshal(demandJSON{
+		Claims:  d.claims,
+		Latency: d.latency,
+	})
+}
+
+func (d *Demand) UnmarshalJSON(b []byte) error {
+	dj := demandJSON{}
+	if err := json.Unmarshal(b, &dj); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.claims = dj.Claims
+	d.latency = dj.Latency
+	return nil
+}
+
+func (d *Demand) prune(t time.Time) []time.Time {
+	i := 0
+	for i < len(d.claims) && t.Sub(d.claims[i]) > DemandWindow {
+		i++
+	}
+	return d.claims[i:]
+}
+
+// Target computes the pool size that covers the claims expected over the
+// spin-up latency, given the number of claims observed over a window.
+// The result is bounded between minVal and maxVal (0 means no upper bound).
+func Target(claims int64, window, latency time.Duration, minVal, maxVal int64) int64 {
+	target := minVal
+	if window > 0 {
+		expected := int64(math.Ceil(float64(claims) * latency.Seconds() / window.Seconds()))
+		target = max(target, expected)
+	}
+	if maxVal != 0 && target > maxVal {
+		target = maxVal
+	}
+	return target
+}
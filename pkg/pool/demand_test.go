@@ -0,0 +1,111 @@
+package pool_test
+
+import (
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+)
+
+func Test_U_Target(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Claims         int64
+		Window         time.Duration
+		Latency        time.Duration
+		Min, Max       int64
+		ExpectedTarget int64
+	}{
+		"idle": {
+			Claims:         0,
+			Window:         10 * time.Minute,
+			Latency:        5 * time.Minute,
+			Min:            2,
+			Max:            0,
+			ExpectedTarget: 2,
+		},
+		"unknown-latency": {
+			Claims:         30,
+			Window:         10 * time.Minute,
+			Latency:        0,
+			Min:            2,
+			Max:            0,
+			ExpectedTarget: 2,
+		},
+		"rush": {
+			// 3 claims per minute, and it takes 5 minutes to deploy one
+			Claims:         30,
+			Window:         10 * time.Minute,
+			Latency:        5 * time.Minute,
+			Min:            2,
+			Max:            0,
+			ExpectedTarget: 15,
+		},
+		"rush-capped": {
+			Claims:         30,
+			Window:         10 * time.Minute,
+			Latency:        5 * time.Minute,
+			Min:            2,
+			Max:            10,
+			ExpectedTarget: 10,
+		},
+		"partial-claim": {
+			// 1 claim per 10 minutes, with 1 minute to deploy covers 0.1 claim
+			Claims:         1,
+			Window:         10 * time.Minute,
+			Latency:        time.Minute,
+			Min:            0,
+			Max:            0,
+			ExpectedTarget: 1,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			target := pool.Target(tt.Claims, tt.Window, tt.Latency, tt.Min, tt.Max)
+			assert.Equal(t, tt.ExpectedTarget, target)
+		})
+	}
+}
+
+func Test_U_DemandShrink(t *testing.T) {
+	t.Parallel()
+
+	d := &pool.Demand{}
+	d.SpinUp(5 * time.Minute)
+
+	now := time.Now()
+	for i := range 30 {
+		d.Claim(now.Add(time.Duration(i) * time.Second))
+	}
+	assert.Equal(t, int64(15), d.Target(now.Add(time.Minute), 2, 0))
+
+	// Once idle for longer than the window, it shrinks back to the minimum
+	assert.Equal(t, int64(2), d.Target(now.Add(pool.DemandWindow+time.Minute), 2, 0))
+}
+
+func Test_U_DemandJSON(t *testing.T) {
+	t.Parallel()
+
+	d := &pool.Demand{}
+	d.SpinUp(5 * time.Minute)
+	now := time.Now()
+	for i := range 30 {
+		d.Claim(now.Add(time.Duration(i) * time.Second))
+	}
+
+	// Shared between replicas, it computes the same target
+	b, err := json.Marshal(d)
+	require.NoError(t, err)
+	shared := &pool.Demand{}
+	require.NoError(t, json.Unmarshal(b, shared))
+	assert.Equal(t, 5*time.Minute, shared.Latency())
+	assert.Equal(t, d.Target(now.Add(time.Minute), 2, 0), shared.Target(now.Add(time.Minute), 2, 0))
+}
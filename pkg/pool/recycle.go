@@ -0,0 +1,25 @@
+package pool
+
+import "time"
+
+// Stale returns whether a pooled instance deployed at since exceeded the
+// maximum age at the given time. A nil maxAge never considers instances as
+// stale.
+func Stale(since, t time.Time, maxAge *time.Duration) bool {
+	return maxAge != nil && t.Sub(since) > *maxAge
+}
+
+// Recycle computes the operations to renew one stale pooled instance, given
+// the pool is at its desired size.
+// A replacement is spun up first, while the stale instance is left for the
+// next resize to delete, such that the pool never drops below its minimum.
+// If the maximum is reached there is no room for a replacement, so the stale
+// instance is deleted right away.
+//
+// Expects all integers to be positive.
+func Recycle(maxVal, numClaimed, numPooled int64) Delta {
+	if maxVal != 0 && numClaimed+numPooled >= maxVal {
+		return Delta{Create: 1, Delete: 1}
+	}
+	return Delta{Create: 1}
+}
@@ -0,0 +1,89 @@
+package pool_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_U_Stale(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	maxAge := time.Hour
+
+	var tests = map[string]struct {
+		Since    time.Time
+		MaxAge   *time.Duration
+		Expected bool
+	}{
+		"no-max-age": {
+			Since:    now.Add(-24 * time.Hour),
+			MaxAge:   nil,
+			Expected: false,
+		},
+		"young": {
+			Since:    now.Add(-time.Minute),
+			MaxAge:   &maxAge,
+			Expected: false,
+		},
+		"exact-age": {
+			Since:    now.Add(-time.Hour),
+			MaxAge:   &maxAge,
+			Expected: false,
+		},
+		"stale": {
+			Since:    now.Add(-2 * time.Hour),
+			MaxAge:   &maxAge,
+			Expected: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.Expected, pool.Stale(tt.Since, now, tt.MaxAge))
+		})
+	}
+}
+
+func Test_U_Recycle(t *testing.T) {
+	t.Parallel()
+
+	var tests = map[string]struct {
+		Max, NumClaimed, NumPooled int64
+		ExpectedDelta              pool.Delta
+	}{
+		"no-max": {
+			Max:        0,
+			NumClaimed: 4,
+			NumPooled:  2,
+			// Replace first, delete on next resize
+			ExpectedDelta: pool.Delta{Create: 1},
+		},
+		"room-left": {
+			Max:           10,
+			NumClaimed:    4,
+			NumPooled:     2,
+			ExpectedDelta: pool.Delta{Create: 1},
+		},
+		"max-reached": {
+			Max:        6,
+			NumClaimed: 4,
+			NumPooled:  2,
+			// No room for a replacement, swap it
+			ExpectedDelta: pool.Delta{Create: 1, Delete: 1},
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.ExpectedDelta, pool.Recycle(tt.Max, tt.NumClaimed, tt.NumPooled))
+		})
+	}
+}
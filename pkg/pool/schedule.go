@@ -0,0 +1,29 @@
+package pool
+
+import "time"
+
+// Window defines the pool boundaries to apply in a time range.
+// It is inclusive of From and exclusive of To.
+type Window struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	Min  int64     `json:"min"`
+	Max  int64     `json:"max"`
+}
+
+// Contains returns whether the window is active at the given time.
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.From) && t.Before(w.To)
+}
+
+// Bounds returns the pool boundaries to apply at the given time.
+// The first window of the schedule that contains it wins, else the
+// default boundaries apply.
+func Bounds(schedule []Window, t time.Time, defMin, defMax int64) (minVal, maxVal int64) {
+	for _, w := range schedule {
+		if w.Contains(t) {
+			return w.Min, w.Max
+		}
+	}
+	return defMin, defMax
+}
@@ -0,0 +1,72 @@
+package pool_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_U_Bounds(t *testing.T) {
+	t.Parallel()
+
+	opening := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	schedule := []pool.Window{
+		{
+			// Pre-warm 30 minutes before the CTF opens, until 2 hours after
+			From: opening.Add(-30 * time.Minute),
+			To:   opening.Add(2 * time.Hour),
+			Min:  50,
+			Max:  0,
+		},
+	}
+
+	var tests = map[string]struct {
+		Schedule    []pool.Window
+		Time        time.Time
+		ExpectedMin int64
+		ExpectedMax int64
+	}{
+		"no-schedule": {
+			Schedule:    nil,
+			Time:        opening,
+			ExpectedMin: 5,
+			ExpectedMax: 10,
+		},
+		"before-window": {
+			Schedule:    schedule,
+			Time:        opening.Add(-time.Hour),
+			ExpectedMin: 5,
+			ExpectedMax: 10,
+		},
+		"window-start": {
+			Schedule:    schedule,
+			Time:        opening.Add(-30 * time.Minute),
+			ExpectedMin: 50,
+			ExpectedMax: 0,
+		},
+		"in-window": {
+			Schedule:    schedule,
+			Time:        opening,
+			ExpectedMin: 50,
+			ExpectedMax: 0,
+		},
+		"window-end": {
+			Schedule:    schedule,
+			Time:        opening.Add(2 * time.Hour),
+			ExpectedMin: 5,
+			ExpectedMax: 10,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			minVal, maxVal := pool.Bounds(tt.Schedule, tt.Time, 5, 10)
+			assert.Equal(t, tt.ExpectedMin, minVal)
+			assert.Equal(t, tt.ExpectedMax, maxVal)
+		})
+	}
+}
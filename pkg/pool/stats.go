@@ -0,0 +1,102 @@
+package pool
+
+import (
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// MaxFailures is the number of recent spin-up failures kept by [Stats].
+const MaxFailures = 10
+
+// Failure of a spin-up.
+type Failure struct {
+	At    time.Time `json:"at"`
+	Error string    `json:"error"`
+}
+
+// Stats keeps track of a pool spin-ups, for observability purposes.
+// It is safe for concurrent use.
+type Stats struct {
+	mu       sync.Mutex
+	count    int64
+	total    time.Duration
+	failures []Failure
+}
+
+// Success records a successful spin-up and the time it took.
+func (s *Stats) Success(dur time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.count++
+	s.total += dur
+}
+
+// Failure records a failed spin-up. Only the [MaxFailures] most recent are kept.
+func (s *Stats) Failure(t time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, Failure{
+		At:    t,
+		Error: err.Error(),
+	})
+	if len(s.failures) > MaxFailures {
+		s.failures = s.failures[len(s.failures)-MaxFailures:]
+	}
+}
+
+// Failures returns the most recent spin-up failures, oldest first.
+func (s *Stats) Failures() []Failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Failure(nil), s.failures...)
+}
+
+// Average returns the average spin-up time, or 0 if none succeeded yet.
+func (s *Stats) Average() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.count == 0 {
+		return 0
+	}
+	return s.total / time.Duration(s.count)
+}
+
+// statsJSON is the JSON representation of [Stats], such that they can be
+// shared between replicas.
+type statsJSON struct {
+	Count    int64         `json:"count,omitempty"`
+	Total    time.Duration `json:"total,omitempty"`
+	Failures []Failure     `json:"failures,omitempty"`
+}
+
+func (s *Stats) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return json.Marshal(statsJSON{
+		Count:    s.count,
+		Total:    s.total,
+		Failures: s.failures,
+	})
+}
+
+func (s *Stats) UnmarshalJSON(b []byte) error {
+	sj := statsJSON{}
+	if err := json.Unmarshal(b, &sj); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.count = sj.Count
+	s.total = sj.Total
+	s.failures = sj.Failures
+	return nil
+}
@@ -0,0 +1,53 @@
+package pool_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+)
+
+func Test_U_Stats(t *testing.T) {
+	t.Parallel()
+
+	s := &pool.Stats{}
+	assert.Equal(t, time.Duration(0), s.Average())
+	assert.Empty(t, s.Failures())
+
+	s.Success(time.Second)
+	s.Success(3 * time.Second)
+	assert.Equal(t, 2*time.Second, s.Average())
+
+	now := time.Now()
+	for i := range pool.MaxFailures + 2 {
+		s.Failure(now, fmt.Errorf("failure %d", i))
+	}
+	failures := s.Failures()
+	assert.Len(t, failures, pool.MaxFailures)
+	assert.Equal(t, "failure 2", failures[0].Error)
+	assert.Equal(t, fmt.Sprintf("failure %d", pool.MaxFailures+1), failures[len(failures)-1].Error)
+}
+
+func Test_U_StatsJSON(t *testing.T) {
+	t.Parallel()
+
+	s := &pool.Stats{}
+	s.Success(time.Second)
+	s.Success(3 * time.Second)
+	s.Failure(time.Now(), errors.New("failure"))
+
+	// Shared between replicas, they report the same
+	b, err := json.Marshal(s)
+	require.NoError(t, err)
+	shared := &pool.Stats{}
+	require.NoError(t, json.Unmarshal(b, shared))
+	assert.Equal(t, 2*time.Second, shared.Average())
+	require.Len(t, shared.Failures(), 1)
+	assert.Equal(t, "failure", shared.Failures()[0].Error)
+}
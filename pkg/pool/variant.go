@@ -0,0 +1,42 @@
+package pool
+
+import (
+	"maps"
+	"slices"
+)
+
+// Variant is a named pool of instances pre-provisioned with additional values,
+// such that claims requesting those exact values need no update.
+type Variant struct {
+	Name       string            `json:"name"`
+	Additional map[string]string `json:"additional"`
+	Min        int64             `json:"min"`
+	Max        int64             `json:"max"`
+}
+
+// Match returns the variant whose additional values are exactly the given
+// ones, if any. Empty additional values never match, as they are served by
+// the default pool.
+func Match(variants []Variant, additional map[string]string) (Variant, bool) {
+	if len(additional) == 0 {
+		return Variant{}, false
+	}
+	i := slices.IndexFunc(variants, func(v Variant) bool {
+		return maps.Equal(v.Additional, additional)
+	})
+	if i == -1 {
+		return Variant{}, false
+	}
+	return variants[i], true
+}
+
+// Find returns the variant of the given name, if any.
+func Find(variants []Variant, name string) (Variant, bool) {
+	i := slices.IndexFunc(variants, func(v Variant) bool {
+		return v.Name == name
+	})
+	if i == -1 {
+		return Variant{}, false
+	}
+	return variants[i], true
+}
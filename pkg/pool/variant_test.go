@@ -0,0 +1,60 @@
+package pool_test
+
+import (
+	"testing"
+
+	"github.com/ctfer-io/chall-manager/pkg/pool"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_U_Match(t *testing.T) {
+	t.Parallel()
+
+	variants := []pool.Variant{
+		{Name: "easy", Additional: map[string]string{"level": "easy"}, Min: 2},
+		{Name: "hard", Additional: map[string]string{"level": "hard", "hint": "no"}, Min: 1},
+	}
+
+	var tests = map[string]struct {
+		Additional    map[string]string
+		ExpectedName  string
+		ExpectedMatch bool
+	}{
+		"no-additional": {
+			Additional:    nil,
+			ExpectedMatch: false,
+		},
+		"exact": {
+			Additional:    map[string]string{"level": "easy"},
+			ExpectedName:  "easy",
+			ExpectedMatch: true,
+		},
+		"exact-multiple-keys": {
+			Additional:    map[string]string{"hint": "no", "level": "hard"},
+			ExpectedName:  "hard",
+			ExpectedMatch: true,
+		},
+		"subset": {
+			Additional:    map[string]string{"level": "hard"},
+			ExpectedMatch: false,
+		},
+		"superset": {
+			Additional:    map[string]string{"level": "easy", "hint": "yes"},
+			ExpectedMatch: false,
+		},
+		"other-value": {
+			Additional:    map[string]string{"level": "medium"},
+			ExpectedMatch: false,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			v, ok := pool.Match(variants, tt.Additional)
+			assert.Equal(t, tt.ExpectedMatch, ok)
+			assert.Equal(t, tt.ExpectedName, v.Name)
+		})
+	}
+}
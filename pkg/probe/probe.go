@@ -0,0 +1,93 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Timeout is the maximum duration of a single check.
+const Timeout = 2 * time.Second
+
+// Check the availability of a target.
+// If the target is an HTTP(S) URL, it must respond with a non-error status
+// code. Elseway, it is considered as a TCP host:port that must accept
+// connections.
+func Check(ctx context.Context, target string) error {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	if u, err := url.Parse(target); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = res.Body.Close()
+		if res.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("%s responded with status %d", target, res.StatusCode)
+		}
+		return nil
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Wait checks the target every interval until it is available, or the
+// context is done. In the later case, it returns the last check error.
+func Wait(ctx context.Context, target string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := Check(ctx, target)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks the availability of all targets, in order.
+// It returns the error of the first unavailable one, if any.
+func CheckAll(ctx context.Context, targets []string) error {
+	for _, target := range targets {
+		if err := Check(ctx, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WaitAll checks the targets every interval until they are all available,
+// or the context is done. In the later case, it returns the last check error.
+func WaitAll(ctx context.Context, targets []string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := CheckAll(ctx, targets)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package probe_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/pkg/probe"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_U_Check(t *testing.T) {
+	t.Parallel()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(healthy.Close)
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(unhealthy.Close)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = lis.Close()
+	})
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	require.NoError(t, closed.Close())
+
+	var tests = map[string]struct {
+		Target    string
+		ExpectErr bool
+	}{
+		"http-healthy": {
+			Target:    healthy.URL,
+			ExpectErr: false,
+		},
+		"http-unhealthy": {
+			Target:    unhealthy.URL,
+			ExpectErr: true,
+		},
+		"tcp-listening": {
+			Target:    lis.Addr().String(),
+			ExpectErr: false,
+		},
+		"tcp-closed": {
+			Target:    closedAddr,
+			ExpectErr: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			err := probe.Check(context.Background(), tt.Target)
+			if tt.ExpectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_U_Wait(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := closed.Addr().String()
+	require.NoError(t, closed.Close())
+
+	// Never comes up, so times out with the last check error
+	assert.Error(t, probe.Wait(ctx, addr, 50*time.Millisecond))
+}
+
+func Test_U_WaitAll(t *testing.T) {
+	t.Parallel()
+
+	// Serves errors until the third request
+	calls := atomic.Int32{}
+	starting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(starting.Close)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = lis.Close()
+	})
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	require.NoError(t, closed.Close())
+
+	var tests = map[string]struct {
+		Targets   []string
+		ExpectErr bool
+	}{
+		"none": {
+			Targets:   nil,
+			ExpectErr: false,
+		},
+		"eventually-ready": {
+			Targets:   []string{starting.URL, lis.Addr().String()},
+			ExpectErr: false,
+		},
+		"one-never-ready": {
+			Targets:   []string{lis.Addr().String(), closedAddr},
+			ExpectErr: true,
+		},
+	}
+
+	for testname, tt := range tests {
+		t.Run(testname, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err := probe.WaitAll(ctx, tt.Targets, 50*time.Millisecond)
+			if tt.ExpectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
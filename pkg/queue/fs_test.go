@@ -0,0 +1,42 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/pkg/queue"
+)
+
+func Test_U_FSQueue(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	q := queue.NewFSQueue(t.TempDir())
+
+	// Empty queue, even if not created yet
+	ops, err := q.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	op1 := &queue.Op{ID: "a", ChallengeID: "1", At: at}
+	op2 := &queue.Op{ID: "b", ChallengeID: "1", Variant: "hard", At: at}
+	require.NoError(t, q.Push(ctx, op1))
+	require.NoError(t, q.Push(ctx, op2))
+
+	ops, err = q.List(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*queue.Op{op1, op2}, ops)
+
+	// Completing an operation removes it, even twice
+	require.NoError(t, q.Done(ctx, op1.ID))
+	require.NoError(t, q.Done(ctx, op1.ID))
+
+	ops, err = q.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []*queue.Op{op2}, ops)
+}
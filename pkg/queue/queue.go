@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/ctfer-io/chall-manager/global"
+	"github.com/ctfer-io/chall-manager/pkg/store"
+)
+
+// Op is a pending pool operation, i.e. the spin-up of an instance in a
+// challenge pool that has been requested but has not completed yet.
+type Op struct {
+	ID          string    `json:"id"`
+	ChallengeID string    `json:"challenge_id"`
+	Variant     string    `json:"variant,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+const etcdPrefix = "/chall-manager/pool/queue/"
+
+// Queue persists the pending pool operations, such that they could be resumed
+// if chall-manager stops before they complete (e.g. restarted, rescheduled).
+type Queue = store.Store[Op]
+
+// New returns the Queue that fits the configuration: in etcd when configured,
+// on the filesystem otherwise.
+func New() Queue {
+	return store.New(filepath.Join(global.Conf.Directory, "queue"), etcdPrefix, opID)
+}
+
+// NewFSQueue returns a Queue persisting each operation as a JSON file of a
+// directory. It suits a single replica, as the filesystem is not shared.
+func NewFSQueue(dir string) Queue {
+	return store.NewFS(dir, opID)
+}
+
+func opID(op *Op) string {
+	return op.ID
+}
@@ -128,20 +128,46 @@ func (m *Manager) GetSession(ctx context.Context) (*concurrency.Session, uint64,
 	return sess, gen, nil
 }
 
-func (m *Manager) Get(ctx context.Context, k string) (*clientv3.GetResponse, error) {
+func (m *Manager) Get(ctx context.Context, k string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
 	cli, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
 	if err != nil {
 		return nil, err
 	}
-	return cli.Get(ctx, k)
+	return cli.Get(ctx, k, opts...)
 }
 
-func (m *Manager) Put(ctx context.Context, k, v string) (*clientv3.PutResponse, error) {
+func (m *Manager) Put(ctx context.Context, k, v string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
 	cli, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
 	if err != nil {
 		return nil, err
 	}
-	return cli.Put(ctx, k, v)
+	return cli.Put(ctx, k, v, opts...)
+}
+
+func (m *Manager) Delete(ctx context.Context, k string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	cli, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
+	if err != nil {
+		return nil, err
+	}
+	return cli.Delete(ctx, k, opts...)
+}
+
+func (m *Manager) Watch(ctx context.Context, k string, opts ...clientv3.OpOption) (clientv3.WatchChan, error) {
+	cli, err := m.getClient(context.WithoutCancel(ctx)) // avoid cancelation as we'll reuse the client if recreated
+	if err != nil {
+		return nil, err
+	}
+	return cli.Watch(ctx, k, opts...), nil
+}
+
+// Election returns the leader election of the given prefix, bound to the
+// current session. Leadership is lost once this session is done.
+func (m *Manager) Election(ctx context.Context, pfx string) (*concurrency.Election, *concurrency.Session, error) {
+	sess, _, err := m.GetSession(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return concurrency.NewElection(sess, pfx), sess, nil
 }
 
 func (m *Manager) Healthcheck(ctx context.Context) error {
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+
+	json "github.com/goccy/go-json"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/ctfer-io/chall-manager/pkg/services/etcd"
+)
+
+// Etcd is a Store persisting items in etcd under a key prefix, shared by all
+// replicas.
+type Etcd[T any] struct {
+	man    *etcd.Manager
+	prefix string
+	id     func(*T) string
+}
+
+var _ Store[struct{}] = (*Etcd[struct{}])(nil)
+
+func NewEtcd[T any](man *etcd.Manager, prefix string, id func(*T) string) *Etcd[T] {
+	return &Etcd[T]{
+		man:    man,
+		prefix: prefix,
+		id:     id,
+	}
+}
+
+func (s *Etcd[T]) Push(ctx context.Context, v *T) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.man.Put(ctx, s.prefix+s.id(v), string(b))
+	return err
+}
+
+func (s *Etcd[T]) Done(ctx context.Context, id string) error {
+	_, err := s.man.Delete(ctx, s.prefix+id)
+	return err
+}
+
+func (s *Etcd[T]) Get(ctx context.Context, id string) (*T, error) {
+	res, err := s.man.Get(ctx, s.prefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Kvs) == 0 {
+		return nil, nil
+	}
+	v := new(T)
+	if err := json.Unmarshal(res.Kvs[0].Value, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *Etcd[T]) List(ctx context.Context) ([]*T, error) {
+	res, err := s.man.Get(ctx, s.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	vs := make([]*T, 0, len(res.Kvs))
+	for _, kv := range res.Kvs {
+		v := new(T)
+		if err := json.Unmarshal(kv.Value, v); err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
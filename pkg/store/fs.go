@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	json "github.com/goccy/go-json"
+)
+
+// FS is a Store persisting each item as a JSON file of a directory.
+// It suits a single replica, as the filesystem is not shared.
+type FS[T any] struct {
+	dir string
+	id  func(*T) string
+}
+
+var _ Store[struct{}] = (*FS[struct{}])(nil)
+
+func NewFS[T any](dir string, id func(*T) string) *FS[T] {
+	return &FS[T]{
+		dir: dir,
+		id:  id,
+	}
+}
+
+func (s *FS[T]) Push(_ context.Context, v *T) error {
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(s.id(v)), b, 0600)
+}
+
+func (s *FS[T]) Done(_ context.Context, id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FS[T]) Get(_ context.Context, id string) (*T, error) {
+	b, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	v := new(T)
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (s *FS[T]) List(_ context.Context) ([]*T, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	vs := make([]*T, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		v := new(T)
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+func (s *FS[T]) path(id string) string {
+	return filepath.Join(s.dir, filepath.Base(id)+".json")
+}
@@ -0,0 +1,43 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ctfer-io/chall-manager/pkg/store"
+)
+
+type item struct {
+	ID    string `json:"id"`
+	Value int    `json:"value"`
+}
+
+func Test_U_FSGet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := store.NewFS(t.TempDir(), func(it *item) string {
+		return it.ID
+	})
+
+	// Unknown item, even if the store is not created yet
+	it, err := s.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, it)
+
+	require.NoError(t, s.Push(ctx, &item{ID: "a", Value: 1}))
+	require.NoError(t, s.Push(ctx, &item{ID: "a", Value: 2}))
+
+	// Pushing again updates it
+	it, err = s.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, &item{ID: "a", Value: 2}, it)
+
+	require.NoError(t, s.Done(ctx, "a"))
+	it, err = s.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, it)
+}
@@ -0,0 +1,36 @@
+// Package store persists items until they are done with, such that they
+// survive chall-manager restarts (e.g. pending operations, outgoing
+// notifications).
+package store
+
+import (
+	"context"
+
+	"github.com/ctfer-io/chall-manager/global"
+)
+
+// Store persists items of type T, each identified by a unique ID.
+type Store[T any] interface {
+	// Push persists an item, or updates it if already known.
+	Push(ctx context.Context, v *T) error
+
+	// Done removes an item once done with, whatever the outcome.
+	// Removing an unknown item is not an error.
+	Done(ctx context.Context, id string) error
+
+	// Get returns a persisted item, or nil if unknown.
+	Get(ctx context.Context, id string) (*T, error)
+
+	// List returns all persisted items.
+	List(ctx context.Context) ([]*T, error)
+}
+
+// New returns the Store that fits the configuration: in etcd under prefix
+// when configured, in directory dir otherwise.
+// The id function returns the ID of an item.
+func New[T any](dir, prefix string, id func(*T) string) Store[T] {
+	if global.Conf.Etcd.Endpoint == "" {
+		return NewFS(dir, id)
+	}
+	return NewEtcd(global.GetEtcdManager(), prefix, id)
+}
@@ -9,7 +9,8 @@ import (
 )
 
 var (
-	pStrEmpty = pulumi.String("").ToStringOutput()
+	pStrEmpty    = pulumi.String("").ToStringOutput()
+	pStrArrEmpty = pulumi.StringArray{}.ToStringArrayOutput()
 )
 
 // Factory define the prototype a IaC factory have to implement to be used
@@ -30,6 +31,8 @@ func Run(f Factory) {
 		resp := &Response{
 			ConnectionInfo: pStrEmpty,
 			Flag:           pStrEmpty,
+			Healthcheck:    pStrEmpty,
+			Readiness:      pStrArrEmpty,
 		}
 
 		opts := []pulumi.ResourceOption{}
@@ -53,6 +56,12 @@ func Run(f Factory) {
 			ctx.Export("flag", resp.Flag)
 		}
 		ctx.Export("flags", resp.Flags)
+		if resp.Healthcheck != pStrEmpty {
+			ctx.Export("healthcheck", resp.Healthcheck)
+		}
+		if resp.Readiness != pStrArrEmpty {
+			ctx.Export("readiness", resp.Readiness)
+		}
 
 		return nil
 	})
@@ -73,6 +82,15 @@ type Response struct {
 	Flag pulumi.StringOutput
 
 	Flags pulumi.StringArrayOutput
+
+	// Healthcheck is an optional HTTP(S) URL or TCP host:port the chall-manager
+	// checks before handing a pooled instance to a source.
+	Healthcheck pulumi.StringOutput
+
+	// Readiness is an optional list of HTTP(S) URLs or TCP host:port the
+	// chall-manager checks before considering a new instance ready, e.g. to
+	// wait for its ingress and DNS to serve.
+	Readiness pulumi.StringArrayOutput
 }
 
 // Configuration is the struct that contains the flattened configuration
@@ -80,6 +98,12 @@ type Response struct {
 type Configuration struct {
 	Identity   string
 	Additional map[string]string
+
+	// Paused is true when the chall-manager pauses the instance. The factory
+	// should then scale its workloads down to zero, but keep the rest (e.g.
+	// networking) such that it is resumed as it was.
+	// The kubernetes package components handle it on their own.
+	Paused bool
 }
 
 // Load flatten the Pulumi stack configuration into a ready-to-use struct.
@@ -93,5 +117,6 @@ func Load(ctx *pulumi.Context, project string) *Configuration {
 	return &Configuration{
 		Identity:   cfg.Get("identity"),
 		Additional: additional,
+		Paused:     cfg.GetBool("paused"),
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/pulumi"
+	"github.com/pulumi/pulumi/sdk/v3/go/pulumi/config"
 )
 
 const (
@@ -33,6 +34,20 @@ func randName(seed string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// paused returns whether the chall-manager pauses the instance.
+func paused(ctx *pulumi.Context) bool {
+	return config.New(ctx, "").GetBool("paused")
+}
+
+// replicas returns the number of replicas of a workload, i.e. none while
+// the chall-manager pauses the instance.
+func replicas(ctx *pulumi.Context) int {
+	if paused(ctx) {
+		return 0
+	}
+	return 1
+}
+
 func ptr[T any](t T) *T {
 	return &t
 }
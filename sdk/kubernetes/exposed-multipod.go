@@ -483,7 +483,7 @@ func (emp *exposedMultipod) provision(ctx *pulumi.Context, args ExposedMultipodA
 				Selector: metav1.LabelSelectorArgs{
 					MatchLabels: labels,
 				},
-				Replicas: pulumi.Int(1),
+				Replicas: pulumi.Int(replicas(ctx)),
 				Template: &corev1.PodTemplateSpecArgs{
 					Metadata: &metav1.ObjectMetaArgs{
 						Labels: labels,
@@ -446,6 +446,18 @@ func (kmp *Kompose) provision(ctx *pulumi.Context, in KomposeArgsOutput, opts ..
 			}
 			return nil
 		},
+		// Scale down while paused, services and ingresses remain.
+		// Elseway, keep the replicas of the compose file.
+		func(_ context.Context, args *pulumi.ResourceTransformArgs) *pulumi.ResourceTransformResult {
+			if args.Type == "kubernetes:apps/v1:Deployment" && paused(ctx) {
+				args.Props["spec"].(pulumi.Map)["replicas"] = pulumi.Int(0)
+				return &pulumi.ResourceTransformResult{
+					Props: args.Props,
+					Opts:  args.Opts,
+				}
+			}
+			return nil
+		},
 	}))
 
 	// Generate Kubernetes resources